@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// NormalizeRules - какие имейлы считать одним и тем же адресом
+type NormalizeRules struct {
+	FoldCase       bool     // Bruce@Mail.ru и bruce@mail.ru - один адрес
+	StripPlus      bool     // user+tag@mail.ru - это user@mail.ru
+	DotlessDomains []string // домены, где точки в имени ничего не значат, как у gmail
+}
+
+var DefaultNormalizeRules = NormalizeRules{
+	FoldCase:       true,
+	StripPlus:      true,
+	DotlessDomains: []string{"gmail.com", "googlemail.com"},
+}
+
+func (r NormalizeRules) Normalize(email string) string {
+	email = strings.TrimSpace(email)
+	if r.FoldCase {
+		email = strings.ToLower(email)
+	}
+	name, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return email
+	}
+	if r.StripPlus {
+		name, _, _ = strings.Cut(name, "+")
+	}
+	for _, d := range r.DotlessDomains {
+		if strings.EqualFold(domain, d) {
+			name = strings.ReplaceAll(name, ".", "")
+			break
+		}
+	}
+	return name + "@" + domain
+}
+
+// AliasRegistry - таблица алиасов: какой имейл на самом деле чей
+// ключи и значения хранятся уже нормализованными
+type AliasRegistry struct {
+	rules   NormalizeRules
+	mu      sync.RWMutex
+	aliases map[string]string
+}
+
+// NewAliasRegistry - реестр с таблицей aliases, таблица с циклом - ошибка, см. Replace
+func NewAliasRegistry(rules NormalizeRules, aliases map[string]string) (*AliasRegistry, error) {
+	r := &AliasRegistry{rules: rules}
+	if err := r.Replace(aliases); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Replace подменяет таблицу целиком, например после перечитывания файла
+// если алиасы ссылаются друг на друга по кругу, таблица не подменяется и возвращается ошибка
+func (r *AliasRegistry) Replace(aliases map[string]string) error {
+	table := make(map[string]string, len(aliases))
+	for alias, email := range aliases {
+		alias, email = r.rules.Normalize(alias), r.rules.Normalize(email)
+		// после нормализации алиас может совпасть со своим имейлом, такой алиас ничего не значит
+		if alias != email {
+			table[alias] = email
+		}
+	}
+	if err := checkAliasCycles(table); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.aliases = table
+	r.mu.Unlock()
+	return nil
+}
+
+// checkAliasCycles ищет круг в таблице, ключи обходятся по порядку, чтобы ошибка была одна и та же
+func checkAliasCycles(table map[string]string) error {
+	keys := make([]string, 0, len(table))
+	for alias := range table {
+		keys = append(keys, alias)
+	}
+	sort.Strings(keys)
+	checked := make(map[string]bool, len(table))
+	for _, email := range keys {
+		path := make([]string, 0)
+		// где в пути стоит имейл
+		pos := make(map[string]int)
+		for ok := true; ok && !checked[email]; email, ok = table[email] {
+			if i, seen := pos[email]; seen {
+				return fmt.Errorf("alias cycle: %s", strings.Join(append(path[i:], email), " -> "))
+			}
+			pos[email] = len(path)
+			path = append(path, email)
+		}
+		for _, e := range path {
+			checked[e] = true
+		}
+	}
+	return nil
+}
+
+// Resolve - настоящий имейл: нормализуем и идем по алиасам, пока они есть
+func (r *AliasRegistry) Resolve(email string) string {
+	email = r.rules.Normalize(email)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for {
+		next, ok := r.aliases[email]
+		if !ok {
+			return email
+		}
+		email = next
+	}
+}
+
+// settings - правила и таблица одной строкой, ключи таблицы по порядку
+func (r *AliasRegistry) settings() []byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	data, _ := json.Marshal(struct {
+		Rules   NormalizeRules
+		Aliases map[string]string
+	}{r.rules, r.aliases})
+	return data
+}
+
+// LoadAliases читает таблицу алиасов {"алиас": "имейл"} из файла или по http, если src - url
+func LoadAliases(ctx context.Context, src string) (map[string]string, error) {
+	var in io.Reader
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+		if err != nil {
+			return nil, err
+		}
+		client := &http.Client{Timeout: HTTPTimeout}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("load aliases %s: %s", src, resp.Status)
+		}
+		in = resp.Body
+	} else {
+		file, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		in = file
+	}
+	aliases := make(map[string]string)
+	if err := json.NewDecoder(in).Decode(&aliases); err != nil {
+		return nil, fmt.Errorf("load aliases %s: %w", src, err)
+	}
+	return aliases, nil
+}
+
+// MergeGroup - имейлы со входа, которые оказались одним юзером
+type MergeGroup struct {
+	UserID uint64   `json:"user_id"`
+	Email  string   `json:"email"`
+	Inputs []string `json:"inputs"`
+}
+
+// MergeReport собирает, какие имейлы SelectUsers склеил в одного юзера
+type MergeReport struct {
+	mu     sync.Mutex
+	groups map[uint64]*MergeGroup
+}
+
+func NewMergeReport() *MergeReport {
+	return &MergeReport{groups: make(map[uint64]*MergeGroup)}
+}
+
+func (r *MergeReport) add(user User, input string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	g, ok := r.groups[user.ID]
+	if !ok {
+		g = &MergeGroup{UserID: user.ID, Email: user.Email}
+		r.groups[user.ID] = g
+	}
+	g.Inputs = append(g.Inputs, input)
+}
+
+// Merged - только юзеры, к которым пришло больше одного имейла, отсортированные по Email юзера,
+// имейлы внутри группы тоже по алфавиту
+func (r *MergeReport) Merged() []MergeGroup {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := make([]MergeGroup, 0)
+	for _, g := range r.groups {
+		if len(g.Inputs) < 2 {
+			continue
+		}
+		inputs := append([]string(nil), g.Inputs...)
+		sort.Strings(inputs)
+		res = append(res, MergeGroup{UserID: g.UserID, Email: g.Email, Inputs: inputs})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Email < res[j].Email })
+	return res
+}
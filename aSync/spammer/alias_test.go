@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	cases := map[string]string{
+		"Bruce.Wayne@Mail.ru":        "bruce.wayne@mail.ru",
+		"bruce+news@mail.ru":         "bruce@mail.ru",
+		"b.ru.ce+x@gmail.com":        "bruce@gmail.com",
+		"  B.R.U.C.E@GoogleMail.com": "bruce@googlemail.com",
+		"not an email":               "not an email",
+	}
+	for in, want := range cases {
+		assert.Equal(t, want, DefaultNormalizeRules.Normalize(in), in)
+	}
+	assert.Equal(t, "A.b+c@Gmail.com", NormalizeRules{}.Normalize("A.b+c@Gmail.com"))
+}
+
+func TestAliasRegistry(t *testing.T) {
+	r, err := NewAliasRegistry(DefaultNormalizeRules, map[string]string{
+		"Batman@mail.ru":      "Bruce.Wayne@mail.ru",
+		"dark.knight@mail.ru": "batman@mail.ru",
+		"Same@x":              "same@x",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "bruce.wayne@mail.ru", r.Resolve("batman+promo@mail.ru"))
+	assert.Equal(t, "bruce.wayne@mail.ru", r.Resolve("Dark.Knight@mail.ru"))
+	assert.Equal(t, "same@x", r.Resolve("SAME@x"))
+	assert.Equal(t, "nobody@mail.ru", r.Resolve("NoBody@mail.ru"))
+
+	// таблица с кругом не принимается, старая остается
+	err = r.Replace(map[string]string{"c@x": "a@x", "a@x": "b@x", "B@x": "a@x"})
+	assert.EqualError(t, err, "alias cycle: a@x -> b@x -> a@x")
+	assert.Equal(t, "bruce.wayne@mail.ru", r.Resolve("batman@mail.ru"))
+	_, err = NewAliasRegistry(DefaultNormalizeRules, map[string]string{"a@x": "b@x", "b@x": "c@x", "c@x": "a@x"})
+	assert.Error(t, err)
+
+	require.NoError(t, r.Replace(nil))
+	assert.Equal(t, "batman@mail.ru", r.Resolve("batman@mail.ru"))
+}
+
+func TestLoadAliases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aliases.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"batman@mail.ru": "bruce.wayne@mail.ru"}`), 0o600))
+	res, err := LoadAliases(context.Background(), path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"batman@mail.ru": "bruce.wayne@mail.ru"}, res)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/aliases" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"spiderman@mail.ru": "peter.parker@mail.ru"}`)) //nolint: errcheck
+	}))
+	defer srv.Close()
+	res, err = LoadAliases(context.Background(), srv.URL+"/aliases")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"spiderman@mail.ru": "peter.parker@mail.ru"}, res)
+
+	_, err = LoadAliases(context.Background(), srv.URL+"/nope")
+	assert.Error(t, err)
+	_, err = LoadAliases(context.Background(), filepath.Join(t.TempDir(), "nope.json"))
+	assert.Error(t, err)
+}
+
+func TestSelectUsersAliases(t *testing.T) {
+	f := &fakeBackend{delay: time.Millisecond}
+	report := NewMergeReport()
+	registry, err := NewAliasRegistry(DefaultNormalizeRules, map[string]string{"batman@x": "bruce.wayne@x"})
+	require.NoError(t, err)
+	s := NewSpammer(f, f, f).WithAliases(registry).WithReport(report)
+
+	emails := []string{"Bruce.Wayne@x", "batman+news@x", "bruce.wayne@x", "j.doe@gmail.com", "jdoe@gmail.com", "alone@x"}
+	out, errc := Run(context.Background(), s.SelectUsers(), sendAll(emails...))
+	users := collect(out)
+	require.NoError(t, <-errc)
+	assert.Len(t, users, 3)
+	assert.Equal(t, int32(3), f.users)
+
+	merged := report.Merged()
+	require.Len(t, merged, 2)
+	assert.Equal(t, "bruce.wayne@x", merged[0].Email)
+	assert.Equal(t, []string{"Bruce.Wayne@x", "batman+news@x", "bruce.wayne@x"}, merged[0].Inputs)
+	assert.Equal(t, "jdoe@gmail.com", merged[1].Email)
+	assert.Equal(t, []string{"j.doe@gmail.com", "jdoe@gmail.com"}, merged[1].Inputs)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// UserResolver ищет юзера по имейлу, для алиаса отдает настоящего юзера
+type UserResolver interface {
+	GetUser(ctx context.Context, email string) (User, error)
+}
+
+// MessageStore отдает письма юзеров, за раз - не больше GetMessagesMaxUsersBatch юзеров
+type MessageStore interface {
+	GetMessages(ctx context.Context, users ...User) ([]MsgID, error)
+}
+
+// SpamChecker проверяет письмо на спам
+// если сервис просит притормозить, возвращает ErrTooManyRequests
+type SpamChecker interface {
+	HasSpam(ctx context.Context, id MsgID) (bool, error)
+}
+
+// Simulator - сервисы из common.go. сам вызов прервать нельзя, при отмене ctx его результат выкидывается
+// HasSpam не бросается: он короткий, а лимитер должен держать место, пока запрос на самом деле идет
+type Simulator struct{}
+
+func (Simulator) GetUser(ctx context.Context, email string) (User, error) {
+	return await(ctx, func() (User, error) { return GetUser(email), nil })
+}
+
+func (Simulator) GetMessages(ctx context.Context, users ...User) ([]MsgID, error) {
+	return await(ctx, func() ([]MsgID, error) { return GetMessages(users...) })
+}
+
+func (Simulator) HasSpam(ctx context.Context, id MsgID) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return HasSpam(id)
+}
+
+// Spammer собирает звенья поверх заданных сервисов
+type Spammer struct {
+	users      UserResolver
+	messages   MessageStore
+	spam       SpamChecker
+	aliases    *AliasRegistry
+	report     *MergeReport
+	checkpoint *Checkpoint
+	spamLimits *LimitConfig
+	batchWait  time.Duration
+	batchSize  int
+	cache      UserCache
+	combine    CombineConfig
+}
+
+// NewSpammer заводит кеш юзеров на все запуски этого Spammer и его копий из With...
+func NewSpammer(users UserResolver, messages MessageStore, spam SpamChecker) *Spammer {
+	return &Spammer{users: users, messages: messages, spam: spam, cache: NewLRUCache(UserCacheSize, UserCacheTTL)}
+}
+
+// WithAliases - копия, которая перед походом в UserResolver приводит имейл к настоящему по registry
+func (s *Spammer) WithAliases(registry *AliasRegistry) *Spammer {
+	c := *s
+	c.aliases = registry
+	return &c
+}
+
+// WithReport - копия, которая пишет в report, какие имейлы оказались одним юзером
+func (s *Spammer) WithReport(report *MergeReport) *Spammer {
+	c := *s
+	c.report = report
+	return &c
+}
+
+// WithCheckpoint - копия, которая пропускает уже сделанную работу из checkpoint и дописывает туда новую
+func (s *Spammer) WithCheckpoint(checkpoint *Checkpoint) *Spammer {
+	c := *s
+	c.checkpoint = checkpoint
+	return &c
+}
+
+// WithSpamLimits - копия, у которой CheckSpam ходит в антиспам с ограничениями cfg, а не CheckSpamLimits
+func (s *Spammer) WithSpamLimits(cfg LimitConfig) *Spammer {
+	c := *s
+	c.spamLimits = &cfg
+	return &c
+}
+
+// WithUserCache - копия, которая берет юзеров из cache, подойдет любой UserCache
+func (s *Spammer) WithUserCache(cache UserCache) *Spammer {
+	c := *s
+	c.cache = cache
+	return &c
+}
+
+// WithCombine - копия, у которой CombineResults копит и сортирует результаты по cfg
+func (s *Spammer) WithCombine(cfg CombineConfig) *Spammer {
+	c := *s
+	c.combine = cfg
+	return &c
+}
+
+// WithBatchSize - копия, у которой SelectMessages отдает в GetMessages по size юзеров,
+// а не по GetMessagesMaxUsersBatch
+func (s *Spammer) WithBatchSize(size int) *Spammer {
+	c := *s
+	c.batchSize = size
+	return &c
+}
+
+// WithBatchWait - копия, у которой SelectMessages отдает неполную пачку юзеров в GetMessages,
+// если с первого юзера в ней прошло wait. по умолчанию пачка ждет, пока наберется или кончится вход
+func (s *Spammer) WithBatchWait(wait time.Duration) *Spammer {
+	c := *s
+	c.batchWait = wait
+	return &c
+}
+
+// defaultSpammer - то, что используют SelectUsers, SelectMessages и остальные функции из задания
+// имейлы он не нормализует, а кеш у него свой на каждый запуск: в задании GetUser вызывается
+// на каждый уникальный имейл в каждом запуске
+var defaultSpammer = &Spammer{users: Simulator{}, messages: Simulator{}, spam: Simulator{}}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBackend - сервисы с задаваемой задержкой: у юзера столько писем, сколько букв в имени до @,
+// спам - письма с четным ID
+type fakeBackend struct {
+	delay    time.Duration
+	busy     int32 // сколько раз HasSpam ответит ErrTooManyRequests
+	users    int32
+	messages int32
+	spam     int32
+	active   int32 // сколько вызовов сейчас ждут
+}
+
+func (f *fakeBackend) wait(ctx context.Context) error {
+	atomic.AddInt32(&f.active, 1)
+	defer atomic.AddInt32(&f.active, -1)
+	timer := time.NewTimer(f.delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (f *fakeBackend) GetUser(ctx context.Context, email string) (User, error) {
+	atomic.AddInt32(&f.users, 1)
+	if err := f.wait(ctx); err != nil {
+		return User{}, err
+	}
+	return User{ID: uint64(crc32.ChecksumIEEE([]byte(email))), Email: email}, nil
+}
+
+func (f *fakeBackend) GetMessages(ctx context.Context, users ...User) ([]MsgID, error) {
+	atomic.AddInt32(&f.messages, 1)
+	if err := f.wait(ctx); err != nil {
+		return nil, err
+	}
+	res := make([]MsgID, 0)
+	for _, u := range users {
+		name, _, _ := strings.Cut(u.Email, "@")
+		for i := range name {
+			res = append(res, MsgID(u.ID*100+uint64(i)))
+		}
+	}
+	return res, nil
+}
+
+func (f *fakeBackend) HasSpam(ctx context.Context, id MsgID) (bool, error) {
+	atomic.AddInt32(&f.spam, 1)
+	if atomic.AddInt32(&f.busy, -1) >= 0 {
+		return false, ErrTooManyRequests
+	}
+	if err := f.wait(ctx); err != nil {
+		return false, err
+	}
+	return id%2 == 0, nil
+}
+
+func fakeResults(emails ...string) []string {
+	res := make([]MsgData, 0)
+	for _, email := range emails {
+		id := uint64(crc32.ChecksumIEEE([]byte(email)))
+		name, _, _ := strings.Cut(email, "@")
+		for i := range name {
+			msg := MsgID(id*100 + uint64(i))
+			res = append(res, MsgData{ID: msg, HasSpam: msg%2 == 0})
+		}
+	}
+	sortMsgs(res)
+	lines := make([]string, 0, len(res))
+	for _, m := range res {
+		lines = append(lines, formatMsg(m))
+	}
+	return lines
+}
+
+func TestSpammerFakes(t *testing.T) {
+	f := &fakeBackend{delay: 10 * time.Millisecond, busy: 2}
+	s := NewSpammer(f, f, f)
+	emails := []string{"ab@x", "cde@x", "f@x", "ab@x"}
+
+	start := time.Now()
+	res, err := s.CheckEmails(context.Background(), ErrorPolicy{}, emails)
+	require.NoError(t, err)
+	assert.Equal(t, fakeResults("ab@x", "cde@x", "f@x"), res)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, int32(2), f.messages)
+	assert.Equal(t, int32(6+2), f.spam)
+}
+
+func TestCheckEmailsDeadline(t *testing.T) {
+	f := &fakeBackend{delay: time.Second}
+	s := NewSpammer(f, f, f)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	res, err := s.CheckEmails(ctx, ErrorPolicy{Mode: FailFast}, []string{"a@x", "b@x"})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.Empty(t, res)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	// вызовы отменены, а не брошены досиживать свою секунду
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&f.active) == 0 }, 200*time.Millisecond, time.Millisecond)
+}
+
+func TestHTTPBackend(t *testing.T) {
+	f := &fakeBackend{delay: time.Millisecond, busy: 1}
+	srv := httptest.NewServer(NewBackendHandler(f, f, f))
+	defer srv.Close()
+	b := NewHTTPBackend(srv.URL + "/")
+
+	_, err := b.HasSpam(context.Background(), 1)
+	assert.True(t, errors.Is(err, ErrTooManyRequests), err)
+
+	res, err := NewSpammer(b, b, b).CheckEmails(context.Background(), ErrorPolicy{}, []string{"ab@x", "cde@x"})
+	require.NoError(t, err)
+	assert.Equal(t, fakeResults("ab@x", "cde@x"), res)
+
+	_, err = NewHTTPBackend(srv.URL).HasSpam(context.Background(), 0)
+	assert.NoError(t, err)
+	_, err = NewHTTPBackend(srv.URL+"/nope").GetUser(context.Background(), "a@x")
+	assert.ErrorContains(t, err, "404")
+
+	// сервис, который не отвечает, не вешает запрос навсегда
+	hang := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-hang }))
+	defer slow.Close()
+	defer close(hang)
+	b = NewHTTPBackend(slow.URL)
+	assert.Equal(t, HTTPTimeout, b.Client.Timeout)
+	b.Client.Timeout = 50 * time.Millisecond
+	_, err = b.GetUser(context.Background(), "a@x")
+	assert.Error(t, err)
+
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Batch - звено, которое собирает входы в пачки по size штук и отдает каждую пачку в flush
+// пачка уходит раньше, если с первого входа в ней прошло wait. wait <= 0 - ждать, пока пачка
+// наберется или кончится вход. пачки обрабатываются параллельно, каждая в своей горутине,
+// с Ordered из PipelineLimits результаты пачек идут в порядке пачек
+func Batch[In, Out any](size int, wait time.Duration, flush func(ctx context.Context, batch []In, emit func(Out)) error) Stage[In, Out] {
+	if size <= 0 {
+		size = 1
+	}
+	return func(ctx context.Context, in <-chan In, out chan<- Out) error {
+		batches := make(chan tracedBatch[In])
+		go func() {
+			defer close(batches)
+			collectBatches(ctx, in, linksFrom(ctx).in, batches, size, wait)
+		}()
+		return flatMapFrom(ctx, 0, func() ([]In, []uint64, bool) {
+			b, ok := recv(ctx, batches)
+			return b.items, b.parents, ok
+		}, out, flush)
+	}
+}
+
+// tracedBatch - пачка и trace ID значений, из которых она собрана
+type tracedBatch[T any] struct {
+	items   []T
+	parents []uint64
+}
+
+// collectBatches читает in в одной горутине, поэтому ID берет из link сразу после значения
+func collectBatches[T any](ctx context.Context, in <-chan T, link *traceLink, batches chan<- tracedBatch[T], size int, wait time.Duration) {
+	batch := tracedBatch[T]{items: make([]T, 0, size)}
+	var timer *time.Timer
+	var timeout <-chan time.Time
+	flush := func() bool {
+		if timer != nil {
+			timer.Stop()
+			timer, timeout = nil, nil
+		}
+		ok := send(ctx, batches, batch)
+		batch = tracedBatch[T]{items: make([]T, 0, size)}
+		return ok
+	}
+	for {
+		select {
+		case v, ok := <-in:
+			if !ok {
+				if len(batch.items) != 0 {
+					flush()
+				}
+				return
+			}
+			if id := link.pop(); id != 0 {
+				batch.parents = append(batch.parents, id)
+			}
+			batch.items = append(batch.items, v)
+			if len(batch.items) == 1 && wait > 0 {
+				timer = time.NewTimer(wait)
+				timeout = timer.C
+			}
+			if len(batch.items) == size && !flush() {
+				return
+			}
+		case <-timeout:
+			if !flush() {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func batchSizes(size int, wait time.Duration, in <-chan int) []int {
+	sizes := Batch(size, wait, func(ctx context.Context, batch []int, emit func(int)) error {
+		emit(len(batch))
+		return nil
+	})
+	out, _ := Run(context.Background(), sizes, in)
+	res := make([]int, 0)
+	for n := range out {
+		res = append(res, n)
+	}
+	return res
+}
+
+func TestBatchSize(t *testing.T) {
+	res := batchSizes(3, 0, sendAll(1, 2, 3, 4, 5, 6, 7))
+	assert.ElementsMatch(t, []int{3, 3, 1}, res)
+}
+
+func TestBatchWait(t *testing.T) {
+	in := make(chan int)
+	out, errc := Run(context.Background(), Batch(2, 10*time.Millisecond, func(ctx context.Context, batch []int, emit func(int)) error {
+		emit(len(batch))
+		return nil
+	}), in)
+	// вторую пачку шлем, только когда первая ушла по таймауту неполной
+	in <- 1
+	assert.Equal(t, 1, <-out)
+	in <- 2
+	in <- 3
+	close(in)
+	assert.Equal(t, 2, <-out)
+	_, ok := <-out
+	assert.False(t, ok)
+	assert.NoError(t, <-errc)
+}
+
+// при любом размере пачки вызовов GetMessages должно быть минимально возможное число
+func TestSelectMessagesBatchSize(t *testing.T) {
+	defer func(size int) { GetMessagesMaxUsersBatch = size }(GetMessagesMaxUsersBatch)
+	emails := []string{
+		"harry.dubois@mail.ru", "k.kitsuragi@mail.ru", "d.vader@mail.ru",
+		"noname@mail.ru", "e.musk@mail.ru", "spiderman@mail.ru",
+		"red_prince@mail.ru", "tomasangelo@mail.ru", "batman@mail.ru", "bruce.wayne@mail.ru",
+	}
+	for size, calls := range map[int]uint32{1: 9, 4: 3} {
+		GetMessagesMaxUsersBatch = size
+		stat = Stat{}
+		cnt := 0
+		RunPipeline(
+			cmd(newCatStrings(emails, 0)),
+			cmd(SelectUsers),
+			cmd(SelectMessages),
+			cmd(func(in, out chan interface{}) {
+				for range in {
+					cnt++
+				}
+			}),
+		)
+		assert.Equal(t, 42, cnt)
+		assert.Equal(t, calls, stat.RunGetMessages, "размер пачки %d", size)
+		assert.Equal(t, uint32(9), stat.GetMessagesTotalUsers)
+		assert.Zero(t, stat.ErrorGetMessage)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UserCache - кеш юзеров по имейлу
+type UserCache interface {
+	Get(email string) (User, bool)
+	Set(email string, user User)
+}
+
+// размер и время жизни кеша, который Spammer заводит себе сам
+var (
+	UserCacheSize = 1024
+	UserCacheTTL  = time.Minute
+)
+
+// LRUCache - кеш в памяти на size записей, каждая запись живет ttl
+type LRUCache struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	now   func() time.Time
+	items map[string]*list.Element
+	order *list.List
+}
+
+type lruEntry struct {
+	email   string
+	user    User
+	expires time.Time
+}
+
+func NewLRUCache(size int, ttl time.Duration) *LRUCache {
+	return &LRUCache{
+		size:  size,
+		ttl:   ttl,
+		now:   time.Now,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+func (c *LRUCache) Get(email string) (User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[email]
+	if !ok {
+		return User{}, false
+	}
+	entry := el.Value.(*lruEntry)
+	if c.now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.items, email)
+		return User{}, false
+	}
+	c.order.MoveToFront(el)
+	return entry.user, true
+}
+
+func (c *LRUCache) Set(email string, user User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := c.now().Add(c.ttl)
+	if el, ok := c.items[email]; ok {
+		el.Value = &lruEntry{email, user, expires}
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[email] = c.order.PushFront(&lruEntry{email, user, expires})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).email)
+	}
+}
+
+// flightGroup склеивает одинаковые одновременные запросы: f выполняется один раз,
+// остальные ждут ее результат
+type flightGroup[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[T]
+}
+
+type flightCall[T any] struct {
+	done    chan struct{}
+	val     T
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// Do ждет f, пока не отменят ctx. вызов f общий, его контекст отменяется, когда уходит последний,
+// кто его ждал, а следующий запрос с тем же key начнет новый вызов
+func (g *flightGroup[T]) Do(ctx context.Context, key string, f func(ctx context.Context) (T, error)) (val T, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall[T])
+	}
+	call, shared := g.calls[key]
+	if !shared {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &flightCall[T]{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = call
+		go func() {
+			call.val, call.err = f(callCtx)
+			g.forget(key, call)
+			cancel()
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.val, call.err, shared
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			call.cancel()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+		var zero T
+		return zero, ctx.Err(), shared
+	}
+}
+
+func (g *flightGroup[T]) forget(key string, call *flightCall[T]) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
+
+// cachedUsers - GetUser через кеш и со склейкой одинаковых запросов
+type cachedUsers struct {
+	users  UserResolver
+	cache  UserCache
+	flight flightGroup[User]
+}
+
+// newCachedUsers - кеш для запуска SelectUsers, без cache - свой на этот запуск
+func newCachedUsers(users UserResolver, cache UserCache) *cachedUsers {
+	if cache == nil {
+		cache = NewLRUCache(UserCacheSize, UserCacheTTL)
+	}
+	return &cachedUsers{users: users, cache: cache}
+}
+
+func (c *cachedUsers) get(ctx context.Context, email string) (User, error) {
+	if user, ok := c.cache.Get(email); ok {
+		atomic.AddUint32(&cacheStat.Hits, 1)
+		return user, nil
+	}
+	atomic.AddUint32(&cacheStat.Misses, 1)
+	// запрос общий для всех, кто ждет этот имейл: он отменяется, только когда отменили всех
+	user, err, shared := c.flight.Do(ctx, email, func(ctx context.Context) (User, error) {
+		user, err := c.users.GetUser(ctx, email)
+		if err != nil {
+			return User{}, err
+		}
+		c.cache.Set(email, user)
+		// юзер под алиасом - тот же юзер, что и под настоящим имейлом
+		c.cache.Set(user.Email, user)
+		return user, nil
+	})
+	if shared {
+		atomic.AddUint32(&cacheStat.Coalesced, 1)
+	}
+	return user, err
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUCache(t *testing.T) {
+	now := time.Now()
+	c := NewLRUCache(2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", User{ID: 1})
+	c.Set("b", User{ID: 2})
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+	// b давно не спрашивали - его и выкидываем
+	c.Set("c", User{ID: 3})
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	user, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), user.ID)
+
+	now = now.Add(2 * time.Minute)
+	_, ok = c.Get("a")
+	assert.False(t, ok, "запись устарела")
+}
+
+func TestFlightGroup(t *testing.T) {
+	g := flightGroup[int]{}
+	var calls, shared int32
+	start := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			v, err, isShared := g.Do(context.Background(), "key", func(ctx context.Context) (int, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(50 * time.Millisecond)
+				return 42, nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, 42, v)
+			if isShared {
+				atomic.AddInt32(&shared, 1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	assert.Equal(t, int32(1), calls)
+	assert.Equal(t, int32(9), shared)
+}
+
+func TestFlightGroupCancel(t *testing.T) {
+	g := flightGroup[int]{}
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	f := func(ctx context.Context) (int, error) {
+		close(started)
+		<-ctx.Done()
+		close(canceled)
+		return 0, ctx.Err()
+	}
+
+	first, cancelFirst := context.WithCancel(context.Background())
+	second, cancelSecond := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() {
+		_, err, _ := g.Do(first, "key", f)
+		errs <- err
+	}()
+	<-started
+	go func() {
+		_, err, _ := g.Do(second, "key", func(ctx context.Context) (int, error) { return 1, nil })
+		errs <- err
+	}()
+	assert.Eventually(t, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return g.calls["key"].waiters == 2
+	}, time.Second, time.Millisecond)
+
+	// один ушел, второй еще ждет - вызов идет дальше
+	cancelFirst()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+	select {
+	case <-canceled:
+		t.Fatal("call canceled while someone still waits")
+	case <-time.After(20 * time.Millisecond):
+	}
+	cancelSecond()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+	<-canceled
+
+	// после отмены тот же ключ - новый вызов
+	v, err, shared := g.Do(context.Background(), "key", func(ctx context.Context) (int, error) { return 2, nil })
+	assert.NoError(t, err)
+	assert.Equal(t, 2, v)
+	assert.False(t, shared)
+}
+
+func TestSelectUsersCache(t *testing.T) {
+	// у Spammer из NewSpammer кеш общий на все запуски
+	s := NewSpammer(Simulator{}, Simulator{}, Simulator{})
+	selectAll := func(emails ...string) []string {
+		res := []string{}
+		RunPipeline(
+			cmd(newCatStrings(emails, 0)),
+			s.SelectUsers().Cmd(),
+			cmd(newCollectStrings(&res)),
+		)
+		return res
+	}
+
+	// одинаковые одновременные запросы идут в GetUser один раз
+	stat, cacheStat = Stat{}, CacheStat{}
+	res := selectAll("batman@mail.ru", "batman@mail.ru", "batman@mail.ru")
+	assert.Equal(t, []string{"{12499983457589032104 bruce.wayne@mail.ru}"}, res)
+	assert.Equal(t, uint32(1), stat.RunGetUser)
+	assert.Equal(t, CacheStat{Misses: 3, Coalesced: 2}, cacheStat)
+
+	// и алиас, и настоящий имейл уже в кеше
+	stat, cacheStat = Stat{}, CacheStat{}
+	res = selectAll("bruce.wayne@mail.ru", "batman@mail.ru")
+	assert.Equal(t, []string{"{12499983457589032104 bruce.wayne@mail.ru}"}, res)
+	assert.Equal(t, uint32(0), stat.RunGetUser)
+	assert.Equal(t, CacheStat{Hits: 2}, cacheStat)
+
+	// а у функций из задания - свой на каждый запуск
+	stat = Stat{}
+	res = []string{}
+	RunPipeline(cmd(newCatStrings([]string{"batman@mail.ru"}, 0)), cmd(SelectUsers), cmd(newCollectStrings(&res)))
+	assert.Len(t, res, 1)
+	assert.Equal(t, uint32(1), stat.RunGetUser)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// checkpointRecord - строка файла с контрольными точками
+//
+//	{"input":"..."}                            - отпечаток списка имейлов и алиасов, первая строка файла
+//	{"user":{...},"email":"..."}             - имейл разрешен в юзера
+//	{"batch":7,"users":[...],"messages":[...]} - письма пачки юзеров получены
+//	{"msg":123,"has_spam":true}                - письмо проверено на спам
+//	{"emitted":123}                            - результат по письму отдан тому, кто его ждал
+type checkpointRecord struct {
+	Input    string   `json:"input,omitempty"`
+	Email    string   `json:"email,omitempty"`
+	User     *User    `json:"user,omitempty"`
+	Batch    int      `json:"batch,omitempty"`
+	Users    []uint64 `json:"users,omitempty"`
+	Messages []MsgID  `json:"messages,omitempty"`
+	Msg      *MsgID   `json:"msg,omitempty"`
+	HasSpam  bool     `json:"has_spam,omitempty"`
+	Emitted  *MsgID   `json:"emitted,omitempty"`
+}
+
+// Checkpoint запоминает сделанную работу в файле json lines, чтобы после падения не начинать с нуля
+// файл только дописывается. он относится к одному списку имейлов и алиасам: с другими файл не откроется
+type Checkpoint struct {
+	mu      sync.Mutex
+	file    *os.File
+	input   string
+	users   map[string]User
+	batches map[int][]MsgID
+	batchOf map[uint64]int
+	last    int
+	spam    map[MsgID]bool
+	emitted map[MsgID]bool
+}
+
+// inputFingerprint - отпечаток списка имейлов, с порядком, и настроек алиасов, если они есть:
+// юзеры в файле разрешены по этим настройкам, с другими их брать нельзя
+func inputFingerprint(emails []string, aliases *AliasRegistry) string {
+	h := sha256.New()
+	for _, email := range emails {
+		h.Write([]byte(email + "\n"))
+	}
+	if aliases != nil {
+		h.Write([]byte("aliases\x00"))
+		h.Write(aliases.settings())
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// OpenCheckpoint читает то, что уже сделано для списка emails, и открывает файл на дописывание
+// aliases - тот же реестр, что в s.WithAliases, или nil
+// недописанная при падении последняя строка отрезается. файл, сделанный для другого списка или алиасов, - ошибка
+func OpenCheckpoint(path string, emails []string, aliases *AliasRegistry) (*Checkpoint, error) {
+	c := &Checkpoint{
+		users:   make(map[string]User),
+		batches: make(map[int][]MsgID),
+		batchOf: make(map[uint64]int),
+		spam:    make(map[MsgID]bool),
+		emitted: make(map[MsgID]bool),
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	good := 0
+	for line := 1; good < len(data); line++ {
+		end := bytes.IndexByte(data[good:], '\n')
+		if end < 0 {
+			break
+		}
+		rec := checkpointRecord{}
+		if err := json.Unmarshal(data[good:good+end], &rec); err != nil {
+			if good+end+1 < len(data) {
+				return nil, fmt.Errorf("checkpoint %s, line %d: %w", path, line, err)
+			}
+			break
+		}
+		c.apply(rec)
+		good += end + 1
+	}
+	input := inputFingerprint(emails, aliases)
+	if good != 0 && c.input != input {
+		return nil, fmt.Errorf("checkpoint %s was made for another list of emails or aliases", path)
+	}
+
+	c.file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.file.Truncate(int64(good)); err != nil {
+		c.file.Close()
+		return nil, err
+	}
+	if _, err := c.file.Seek(int64(good), 0); err != nil {
+		c.file.Close()
+		return nil, err
+	}
+	if good == 0 {
+		if err := c.save(checkpointRecord{Input: input}); err != nil {
+			c.file.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *Checkpoint) apply(rec checkpointRecord) {
+	switch {
+	case rec.Input != "":
+		c.input = rec.Input
+	case rec.User != nil:
+		c.users[rec.Email] = *rec.User
+	case rec.Batch != 0:
+		c.batches[rec.Batch] = rec.Messages
+		if rec.Batch > c.last {
+			c.last = rec.Batch
+		}
+		for _, id := range rec.Users {
+			c.batchOf[id] = rec.Batch
+		}
+	case rec.Msg != nil:
+		c.spam[*rec.Msg] = rec.HasSpam
+	case rec.Emitted != nil:
+		c.emitted[*rec.Emitted] = true
+	}
+}
+
+// save пишет запись одной строкой и сбрасывает на диск, так после падения может пропасть только последняя
+func (c *Checkpoint) save(rec checkpointRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.apply(rec)
+	if _, err := c.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return c.file.Sync()
+}
+
+func (c *Checkpoint) Close() error {
+	if err := c.file.Sync(); err != nil {
+		c.file.Close()
+		return err
+	}
+	return c.file.Close()
+}
+
+func (c *Checkpoint) user(email string) (User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	user, ok := c.users[email]
+	return user, ok
+}
+
+func (c *Checkpoint) saveUser(email string, user User) error {
+	return c.save(checkpointRecord{Email: email, User: &user})
+}
+
+// batch - пачка, в которой уже получены письма юзера, 0 - такой нет
+func (c *Checkpoint) batch(user User) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.batchOf[user.ID]
+}
+
+func (c *Checkpoint) batchMessages(batch int) []MsgID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.batches[batch]
+}
+
+func (c *Checkpoint) saveBatch(users []User, messages []MsgID) error {
+	c.mu.Lock()
+	// номер занимаем сразу, чтобы параллельная пачка не взяла тот же
+	c.last++
+	batch := c.last
+	c.mu.Unlock()
+
+	ids := make([]uint64, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	return c.save(checkpointRecord{Batch: batch, Users: ids, Messages: messages})
+}
+
+func (c *Checkpoint) hasSpam(id MsgID) (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res, ok := c.spam[id]
+	return res, ok
+}
+
+func (c *Checkpoint) saveSpam(res MsgData) error {
+	return c.save(checkpointRecord{Msg: &res.ID, HasSpam: res.HasSpam})
+}
+
+// pending пропускает только письма, результат по которым еще не отдавали,
+// остальное идет как есть, так же как без контрольных точек
+func (c *Checkpoint) pending(ctx context.Context, in <-chan MsgData, out chan<- MsgData) error {
+	for {
+		m, ok := recv(ctx, in)
+		if !ok {
+			return ctx.Err()
+		}
+		c.mu.Lock()
+		emitted := c.emitted[m.ID]
+		c.mu.Unlock()
+		if emitted {
+			continue
+		}
+		if !send(ctx, out, m) {
+			return ctx.Err()
+		}
+	}
+}
+
+// MarkEmitted записывает, что результат по письму id отдан дальше: напечатан, сохранен и т.п.
+// звать его должен тот, кто забирает результаты, после возобновления такое письмо уже не придет
+func (c *Checkpoint) MarkEmitted(id MsgID) error {
+	return c.save(checkpointRecord{Emitted: &id})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakySpam - антиспам, который падает на части писем
+type flakySpam struct {
+	*fakeBackend
+	fail func(MsgID) bool
+}
+
+func (f flakySpam) HasSpam(ctx context.Context, id MsgID) (bool, error) {
+	if f.fail(id) {
+		return false, errors.New("antispam is down")
+	}
+	return f.fakeBackend.HasSpam(ctx, id)
+}
+
+func TestCheckpointResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	emails := []string{"ab@x", "cde@x", "fghi@x", "j@x", "klmno@x"}
+	want := fakeResults(emails...)
+
+	// первый запуск: часть писем не проверена
+	first := &fakeBackend{delay: time.Millisecond}
+	checkpoint, err := OpenCheckpoint(path, emails, nil)
+	require.NoError(t, err)
+	s := NewSpammer(first, first, flakySpam{first, func(id MsgID) bool { return id%3 == 0 }}).WithCheckpoint(checkpoint)
+	res, err := s.CheckEmails(context.Background(), ErrorPolicy{}, emails)
+	require.Error(t, err)
+	require.NoError(t, checkpoint.Close())
+	assert.NotEmpty(t, res)
+	assert.Less(t, len(res), len(want))
+	failed := len(want) - len(res)
+
+	// с другим списком имейлов файл не откроется
+	_, err = OpenCheckpoint(path, emails[1:], nil)
+	assert.ErrorContains(t, err, "another list of emails")
+
+	// второй запуск идет только за непроверенными письмами и отдает только то, что еще не отдавали
+	second := &fakeBackend{delay: time.Millisecond}
+	checkpoint, err = OpenCheckpoint(path, emails, nil)
+	require.NoError(t, err)
+	defer checkpoint.Close()
+	s = NewSpammer(second, second, second).WithCheckpoint(checkpoint)
+	rest, err := s.CheckEmails(context.Background(), ErrorPolicy{}, emails)
+	require.NoError(t, err)
+	assert.Len(t, rest, failed)
+	assert.ElementsMatch(t, want, append(res, rest...))
+	assert.Zero(t, second.users)
+	assert.Zero(t, second.messages)
+	assert.Equal(t, int32(failed), second.spam)
+}
+
+func TestCheckpointStreamExactlyOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	emails := []string{"ab@x", "cde@x", "fghi@x"}
+	want := fakeResults(emails...)
+	run := func(stopAfter int) []string {
+		f := &fakeBackend{delay: time.Millisecond}
+		checkpoint, err := OpenCheckpoint(path, emails, nil)
+		require.NoError(t, err)
+		defer checkpoint.Close()
+		s := NewSpammer(f, f, f).WithCheckpoint(checkpoint).WithCombine(CombineConfig{Mode: CombineStream, Window: 1})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		out, errc := Run(ctx, s.Results(), sendAll(emails...))
+		res := make([]string, 0)
+		for m := range out {
+			res = append(res, formatMsg(m))
+			require.NoError(t, checkpoint.MarkEmitted(m.ID))
+			// падение сразу после того, как напечатали stopAfter строк
+			if len(res) == stopAfter {
+				cancel()
+				break
+			}
+		}
+		for range out {
+		}
+		<-errc
+		return res
+	}
+
+	printed := run(3)
+	require.Len(t, printed, 3)
+	rest := run(-1)
+	assert.Len(t, rest, len(want)-3)
+	assert.ElementsMatch(t, want, append(printed, rest...))
+}
+
+func TestCheckpointAliases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	emails := []string{"Bruce@Mail.ru", "batman@mail.ru"}
+	registry := func(rules NormalizeRules, table map[string]string) *AliasRegistry {
+		r, err := NewAliasRegistry(rules, table)
+		require.NoError(t, err)
+		return r
+	}
+	table := map[string]string{"batman@mail.ru": "bruce@mail.ru"}
+
+	checkpoint, err := OpenCheckpoint(path, emails, registry(DefaultNormalizeRules, table))
+	require.NoError(t, err)
+	require.NoError(t, checkpoint.Close())
+
+	// юзеры в файле разрешены по старым правилам, с другими настройками файл не откроется
+	for _, r := range []*AliasRegistry{
+		nil,
+		registry(NormalizeRules{}, table),
+		registry(DefaultNormalizeRules, map[string]string{}),
+	} {
+		_, err = OpenCheckpoint(path, emails, r)
+		assert.ErrorContains(t, err, "another list of emails or aliases")
+	}
+
+	checkpoint, err = OpenCheckpoint(path, emails, registry(DefaultNormalizeRules, table))
+	require.NoError(t, err)
+	require.NoError(t, checkpoint.Close())
+}
+
+// с контрольными точками пропускается только уже отданное, повторы идут как и без них
+func TestCheckpointPending(t *testing.T) {
+	checkpoint, err := OpenCheckpoint(filepath.Join(t.TempDir(), "checkpoint.jsonl"), []string{"a@x"}, nil)
+	require.NoError(t, err)
+	defer checkpoint.Close()
+	require.NoError(t, checkpoint.MarkEmitted(2))
+
+	out := make(chan MsgData)
+	go func() {
+		defer close(out)
+		assert.NoError(t, checkpoint.pending(context.Background(), sendAll(MsgData{ID: 1}, MsgData{ID: 2}, MsgData{ID: 1}, MsgData{ID: 3}), out))
+	}()
+	assert.Equal(t, []MsgData{{ID: 1}, {ID: 1}, {ID: 3}}, collect(out))
+}
+
+func TestOpenCheckpointBroken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	emails := []string{"a@x"}
+	good := `{"input":"` + inputFingerprint(emails, nil) + `"}` + "\n" +
+		`{"email":"a@x","user":{"ID":1,"Email":"a@x"}}` + "\n" + `{"msg":5,"has_spam":true}` + "\n"
+	// процесс упал посреди записи
+	require.NoError(t, os.WriteFile(path, []byte(good+`{"msg":6,"has`), 0o600))
+
+	checkpoint, err := OpenCheckpoint(path, emails, nil)
+	require.NoError(t, err)
+	user, ok := checkpoint.user("a@x")
+	assert.True(t, ok)
+	assert.Equal(t, User{ID: 1, Email: "a@x"}, user)
+	spam, ok := checkpoint.hasSpam(5)
+	assert.True(t, ok && spam)
+	_, ok = checkpoint.hasSpam(6)
+	assert.False(t, ok)
+	require.NoError(t, checkpoint.saveSpam(MsgData{ID: 6}))
+	require.NoError(t, checkpoint.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, good+`{"msg":6}`+"\n", string(data))
+
+	// битая строка в середине - это уже не падение, а чужой файл
+	require.NoError(t, os.WriteFile(path, []byte("oops\n"+good), 0o600))
+	_, err = OpenCheckpoint(path, emails, nil)
+	assert.ErrorContains(t, err, "line 1")
+
+	// файл без отпечатка списка - неизвестно, для какого он списка
+	require.NoError(t, os.WriteFile(path, []byte(`{"msg":5,"has_spam":true}`+"\n"), 0o600))
+	_, err = OpenCheckpoint(path, emails, nil)
+	assert.ErrorContains(t, err, "another list of emails")
+
+	// новый файл начинается с отпечатка
+	path = filepath.Join(t.TempDir(), "new.jsonl")
+	checkpoint, err = OpenCheckpoint(path, emails, nil)
+	require.NoError(t, err)
+	require.NoError(t, checkpoint.Close())
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `{"input":"`+inputFingerprint(emails, nil)+`"}`+"\n", string(data))
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CombineMode - как CombineResults копит и сортирует результаты
+type CombineMode int
+
+const (
+	// CombineAll - ждем все результаты и сортируем разом
+	CombineAll CombineMode = iota
+	// CombineStream - сортируем и отдаем окнами по Window штук или раз в WindowWait
+	CombineStream
+	// CombineTopK - отдаем только первые TopK результатов
+	CombineTopK
+	// CombineExternal - сортировка на диске, в памяти держим не больше MemLimit результатов
+	CombineExternal
+)
+
+// CombineConfig задается через Spammer.WithCombine, по умолчанию - CombineAll
+type CombineConfig struct {
+	Mode       CombineMode
+	Window     int
+	WindowWait time.Duration
+	TopK       int
+	MemLimit   int
+	FanIn      int    // сколько кусков CombineExternal сливает за раз, по умолчанию defaultFanIn
+	TempDir    string // где хранить куски для CombineExternal, по умолчанию os.TempDir()
+}
+
+// defaultFanIn - столько файлов с кусками CombineExternal держит открытыми разом
+const defaultFanIn = 64
+
+// msgLess - сначала спам, потом по ID
+func msgLess(a, b MsgData) bool {
+	if a.HasSpam == b.HasSpam {
+		return a.ID < b.ID
+	}
+	return a.HasSpam
+}
+
+func sortMsgs(mas []MsgData) {
+	sort.Slice(mas, func(k, l int) bool { return msgLess(mas[k], mas[l]) })
+}
+
+func formatMsg(m MsgData) string {
+	return fmt.Sprintf("%v %v", m.HasSpam, m.ID)
+}
+
+// formatMsgs переводит результаты в строки "<has_spam> <msg_id>"
+func formatMsgs(ctx context.Context, in <-chan MsgData, out chan<- string) error {
+	for {
+		m, ok := recv(ctx, in)
+		if !ok {
+			return ctx.Err()
+		}
+		if !send(ctx, out, formatMsg(m)) {
+			return ctx.Err()
+		}
+	}
+}
+
+func parseMsg(line string) (MsgData, error) {
+	spam, id, ok := strings.Cut(line, " ")
+	if !ok {
+		return MsgData{}, fmt.Errorf("bad result line %q", line)
+	}
+	hasSpam, err := strconv.ParseBool(spam)
+	if err != nil {
+		return MsgData{}, fmt.Errorf("bad result line %q: %w", line, err)
+	}
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return MsgData{}, fmt.Errorf("bad result line %q: %w", line, err)
+	}
+	return MsgData{ID: MsgID(n), HasSpam: hasSpam}, nil
+}
+
+func emitMsgs(ctx context.Context, out chan<- MsgData, mas []MsgData) error {
+	for _, m := range mas {
+		if !send(ctx, out, m) {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func combineAll(ctx context.Context, in <-chan MsgData, out chan<- MsgData) error {
+	mas := make([]MsgData, 0)
+	for {
+		m, ok := recv(ctx, in)
+		if !ok {
+			break
+		}
+		mas = append(mas, m)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	sortMsgs(mas)
+	return emitMsgs(ctx, out, mas)
+}
+
+// combineStream - порядок соблюдается внутри окна
+func combineStream(cfg CombineConfig) Stage[MsgData, MsgData] {
+	return func(ctx context.Context, in <-chan MsgData, out chan<- MsgData) error {
+		size := cfg.Window
+		if size <= 0 {
+			size = 1
+		}
+		windows := make(chan tracedBatch[MsgData])
+		go func() {
+			defer close(windows)
+			collectBatches(ctx, in, nil, windows, size, cfg.WindowWait)
+		}()
+		for window := range windows {
+			sortMsgs(window.items)
+			if err := emitMsgs(ctx, out, window.items); err != nil {
+				return err
+			}
+		}
+		return ctx.Err()
+	}
+}
+
+// msgHeap - куча, на вершине которой худший по порядку результат
+type msgHeap []MsgData
+
+func (h msgHeap) Len() int            { return len(h) }
+func (h msgHeap) Less(i, j int) bool  { return msgLess(h[j], h[i]) }
+func (h msgHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *msgHeap) Push(x interface{}) { *h = append(*h, x.(MsgData)) }
+func (h *msgHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// drainMsgs вычитывает вход звена с неверными настройками, иначе встанут звенья до него
+func drainMsgs(ctx context.Context, in <-chan MsgData) {
+	for {
+		if _, ok := recv(ctx, in); !ok {
+			return
+		}
+	}
+}
+
+func combineTopK(k int) Stage[MsgData, MsgData] {
+	return func(ctx context.Context, in <-chan MsgData, out chan<- MsgData) error {
+		if k <= 0 {
+			drainMsgs(ctx, in)
+			return fmt.Errorf("combine: need TopK > 0, got %d", k)
+		}
+		h := &msgHeap{}
+		for {
+			m, ok := recv(ctx, in)
+			if !ok {
+				break
+			}
+			if h.Len() < k {
+				heap.Push(h, m)
+			} else if msgLess(m, (*h)[0]) {
+				(*h)[0] = m
+				heap.Fix(h, 0)
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		mas := []MsgData(*h)
+		sortMsgs(mas)
+		return emitMsgs(ctx, out, mas)
+	}
+}
+
+// sortedRun - отсортированный кусок результатов в файле
+type sortedRun struct {
+	file    *os.File
+	scanner *bufio.Scanner
+	head    MsgData
+}
+
+func (r *sortedRun) next() (bool, error) {
+	if !r.scanner.Scan() {
+		return false, r.scanner.Err()
+	}
+	m, err := parseMsg(r.scanner.Text())
+	if err != nil {
+		return false, err
+	}
+	r.head = m
+	return true, nil
+}
+
+type runHeap []*sortedRun
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return msgLess(h[i].head, h[j].head) }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*sortedRun)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+func writeRun(dir string, n int, mas []MsgData) (string, error) {
+	sortMsgs(mas)
+	path := filepath.Join(dir, "run"+strconv.Itoa(n))
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriter(file)
+	for _, m := range mas {
+		if _, err := w.WriteString(formatMsg(m) + "\n"); err != nil {
+			file.Close()
+			return "", err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return "", err
+	}
+	return path, file.Close()
+}
+
+// mergeRuns сливает отсортированные куски из paths и отдает результаты по порядку в emit
+func mergeRuns(paths []string, emit func(MsgData) error) error {
+	h := make(runHeap, 0, len(paths))
+	defer func() {
+		for _, r := range h {
+			r.file.Close()
+		}
+	}()
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		r := &sortedRun{file: file, scanner: bufio.NewScanner(file)}
+		ok, err := r.next()
+		if err != nil || !ok {
+			file.Close()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		h = append(h, r)
+	}
+	heap.Init(&h)
+	for h.Len() != 0 {
+		r := h[0]
+		if err := emit(r.head); err != nil {
+			return err
+		}
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			r.file.Close()
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
+
+// mergePass сливает куски группами по fanIn в новые куски, пока их не останется не больше fanIn
+func mergePass(dir string, paths []string, fanIn int, next int) ([]string, error) {
+	for len(paths) > fanIn {
+		merged := make([]string, 0, (len(paths)+fanIn-1)/fanIn)
+		for len(paths) != 0 {
+			group := paths[:min(fanIn, len(paths))]
+			paths = paths[len(group):]
+			path, err := mergeToFile(dir, next, group)
+			if err != nil {
+				return nil, err
+			}
+			next++
+			merged = append(merged, path)
+		}
+		paths = merged
+	}
+	return paths, nil
+}
+
+func mergeToFile(dir string, n int, paths []string) (string, error) {
+	path := filepath.Join(dir, "run"+strconv.Itoa(n))
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriter(file)
+	err = mergeRuns(paths, func(m MsgData) error {
+		_, err := w.WriteString(formatMsg(m) + "\n")
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	// слитые куски больше не нужны
+	for _, p := range paths {
+		os.Remove(p)
+	}
+	return path, nil
+}
+
+// combineExternal сбрасывает на диск отсортированные куски по MemLimit результатов,
+// а в конце сливает их, открывая не больше FanIn файлов разом
+func combineExternal(cfg CombineConfig) Stage[MsgData, MsgData] {
+	return func(ctx context.Context, in <-chan MsgData, out chan<- MsgData) error {
+		limit, fanIn := cfg.MemLimit, cfg.FanIn
+		if fanIn == 0 {
+			fanIn = defaultFanIn
+		}
+		if limit <= 0 || fanIn < 2 {
+			drainMsgs(ctx, in)
+			return fmt.Errorf("combine: need MemLimit > 0 and FanIn >= 2, got %d and %d", cfg.MemLimit, cfg.FanIn)
+		}
+		dir, err := os.MkdirTemp(cfg.TempDir, "combine")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		paths := make([]string, 0)
+		mas := make([]MsgData, 0, limit)
+		for {
+			m, ok := recv(ctx, in)
+			if !ok {
+				break
+			}
+			mas = append(mas, m)
+			if len(mas) == limit {
+				path, err := writeRun(dir, len(paths), mas)
+				if err != nil {
+					return err
+				}
+				paths = append(paths, path)
+				mas = mas[:0]
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if len(mas) != 0 {
+			path, err := writeRun(dir, len(paths), mas)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, path)
+		}
+
+		if paths, err = mergePass(dir, paths, fanIn, len(paths)); err != nil {
+			return err
+		}
+		return mergeRuns(paths, func(m MsgData) error {
+			if !send(ctx, out, m) {
+				return ctx.Err()
+			}
+			return nil
+		})
+	}
+}
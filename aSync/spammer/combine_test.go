@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func randomMsgs(n int) []MsgData {
+	r := rand.New(rand.NewSource(1)) //nolint: gosec
+	mas := make([]MsgData, 0, n)
+	for i := 0; i < n; i++ {
+		mas = append(mas, MsgData{ID: MsgID(r.Uint64()), HasSpam: r.Intn(2) == 1})
+	}
+	return mas
+}
+
+func combine(t *testing.T, cfg CombineConfig, mas []MsgData) []string {
+	t.Helper()
+	out, errc := Run(context.Background(), defaultSpammer.WithCombine(cfg).CombineResults(), sendAll(mas...))
+	res := collect(out)
+	assert.NoError(t, <-errc)
+	return res
+}
+
+func expectedOrder(mas []MsgData) []string {
+	sorted := append([]MsgData(nil), mas...)
+	sortMsgs(sorted)
+	res := make([]string, 0, len(sorted))
+	for _, m := range sorted {
+		res = append(res, formatMsg(m))
+	}
+	return res
+}
+
+func TestCombineModes(t *testing.T) {
+	mas := randomMsgs(1000)
+	expected := expectedOrder(mas)
+
+	assert.Equal(t, expected, combine(t, CombineConfig{Mode: CombineAll}, mas))
+	assert.Equal(t, expected[:10], combine(t, CombineConfig{Mode: CombineTopK, TopK: 10}, mas))
+	assert.Equal(t, expected, combine(t, CombineConfig{Mode: CombineTopK, TopK: 5000}, mas))
+	assert.Equal(t, expected, combine(t, CombineConfig{Mode: CombineExternal, MemLimit: 64, TempDir: t.TempDir()}, mas))
+	// 100 кусков по 10 сливаются в несколько проходов по 3
+	assert.Equal(t, expected, combine(t, CombineConfig{Mode: CombineExternal, MemLimit: 10, FanIn: 3, TempDir: t.TempDir()}, mas))
+
+	// в потоковом режиме каждое окно отсортировано само по себе
+	res := combine(t, CombineConfig{Mode: CombineStream, Window: 100}, mas)
+	assert.Len(t, res, len(mas))
+	for i := 0; i < len(mas); i += 100 {
+		assert.Equal(t, expectedOrder(mas[i:i+100]), res[i:i+100])
+	}
+}
+
+func TestCombineExternalCleanup(t *testing.T) {
+	dir := t.TempDir()
+	combine(t, CombineConfig{Mode: CombineExternal, MemLimit: 3, TempDir: dir}, randomMsgs(10))
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries, "куски должны удаляться")
+}
+
+func TestCombineBadConfig(t *testing.T) {
+	for _, cfg := range []CombineConfig{
+		{Mode: CombineTopK},
+		{Mode: CombineTopK, TopK: -1},
+		{Mode: CombineExternal},
+		{Mode: CombineExternal, MemLimit: 10, FanIn: 1},
+	} {
+		out, errc := Run(context.Background(), defaultSpammer.WithCombine(cfg).CombineResults(), sendAll(randomMsgs(10)...))
+		assert.Empty(t, collect(out))
+		assert.Error(t, <-errc)
+	}
+}
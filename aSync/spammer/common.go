@@ -26,6 +26,12 @@ type MsgData struct {
 	HasSpam bool
 }
 
+// алиасы, которые знает "база"
+var userAliases = map[string]string{
+	"batman@mail.ru":    "bruce.wayne@mail.ru",
+	"spiderman@mail.ru": "peter.parker@mail.ru",
+}
+
 // идем в "базу" чтоб получить user_id из email'а
 // каждый запрос занимает 1 секунду
 // можно без проблем выполнять параллельно
@@ -39,10 +45,7 @@ func GetUser(email string) (res User) {
 	time.Sleep(time.Second)
 
 	remail := email
-	alias := map[string]string{
-		"batman@mail.ru":    "bruce.wayne@mail.ru",
-		"spiderman@mail.ru": "peter.parker@mail.ru",
-	}[remail]
+	alias := userAliases[remail]
 	if alias != "" {
 		remail = alias
 	}
@@ -87,6 +90,9 @@ func GetMessages(users ...User) (res []MsgID, err error) {
 	return messages, nil
 }
 
+// ErrTooManyRequests - антибрут антиспама, запрос можно повторить попозже
+var ErrTooManyRequests = errors.New("too many requests")
+
 var antispamConcurrentRequests int32 = 0
 var antispamRequestStart = func() bool {
 	cr := atomic.AddInt32(&antispamConcurrentRequests, 1)
@@ -115,7 +121,7 @@ func HasSpam(id MsgID) (res bool, err error) {
 	if !ok {
 		atomic.AddUint32(&stat.ErrorHasSpam, 1)
 		log.Printf("got antibrute error from antispam for message %d", id)
-		return true, errors.New("too many requests")
+		return true, ErrTooManyRequests
 	}
 
 	// это симуляция похода в сервис антиспама и получения факта реального наличия спама в письме
@@ -133,3 +139,13 @@ type Stat struct {
 }
 
 var stat = Stat{}
+
+// CacheStat - как отработал кеш юзеров в SelectUsers
+// Coalesced - запросы, которые дождались такого же запроса, уже идущего в GetUser
+type CacheStat struct {
+	Hits      uint32
+	Misses    uint32
+	Coalesced uint32
+}
+
+var cacheStat = CacheStat{}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPBackend ходит в сервисы по http:
+//
+//	GET  /user?email=...  -> User
+//	POST /messages        []User -> []MsgID
+//	GET  /spam?id=...     -> {"has_spam": bool}, 429 - ErrTooManyRequests
+//
+// такой же api отдает NewBackendHandler
+type HTTPBackend struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+// HTTPTimeout - сколько ждать ответа на запрос у HTTPBackend и LoadAliases
+var HTTPTimeout = 30 * time.Second
+
+func NewHTTPBackend(baseURL string) *HTTPBackend {
+	return &HTTPBackend{BaseURL: strings.TrimRight(baseURL, "/"), Client: &http.Client{Timeout: HTTPTimeout}}
+}
+
+type spamResponse struct {
+	HasSpam bool `json:"has_spam"`
+}
+
+func (b *HTTPBackend) GetUser(ctx context.Context, email string) (User, error) {
+	user := User{}
+	err := b.do(ctx, http.MethodGet, "/user?email="+url.QueryEscape(email), nil, &user)
+	return user, err
+}
+
+func (b *HTTPBackend) GetMessages(ctx context.Context, users ...User) ([]MsgID, error) {
+	res := make([]MsgID, 0)
+	err := b.do(ctx, http.MethodPost, "/messages", users, &res)
+	return res, err
+}
+
+func (b *HTTPBackend) HasSpam(ctx context.Context, id MsgID) (bool, error) {
+	res := spamResponse{}
+	err := b.do(ctx, http.MethodGet, "/spam?id="+strconv.FormatUint(uint64(id), 10), nil, &res)
+	return res.HasSpam, err
+}
+
+func (b *HTTPBackend) do(ctx context.Context, method, path string, body, res interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, b.BaseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return json.NewDecoder(resp.Body).Decode(res)
+	case http.StatusTooManyRequests:
+		return ErrTooManyRequests
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+}
+
+// NewBackendHandler отдает сервисы по http в том виде, в котором их ждет HTTPBackend
+// например, NewBackendHandler(Simulator{}, Simulator{}, Simulator{}) - локальная замена настоящим сервисам
+func NewBackendHandler(users UserResolver, messages MessageStore, spam SpamChecker) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		user, err := users.GetUser(r.Context(), r.URL.Query().Get("email"))
+		writeBackendResponse(w, user, err)
+	})
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		list := make([]User, 0)
+		if err := json.NewDecoder(r.Body).Decode(&list); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		res, err := messages.GetMessages(r.Context(), list...)
+		writeBackendResponse(w, res, err)
+	})
+	mux.HandleFunc("/spam", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "bad id", http.StatusBadRequest)
+			return
+		}
+		res, err := spam.HasSpam(r.Context(), MsgID(id))
+		writeBackendResponse(w, spamResponse{res}, err)
+	})
+	return mux
+}
+
+func writeBackendResponse(w http.ResponseWriter, res interface{}, err error) {
+	switch {
+	case errors.Is(err, ErrTooManyRequests):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Printf("write response: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// LimitConfig - ограничения на походы во внешний сервис
+type LimitConfig struct {
+	MaxInFlight int           // сколько запросов одновременно
+	PerSecond   float64       // сколько запросов в секунду, 0 - без ограничения
+	Retries     int           // сколько раз повторять запрос, если сервис ответил "too many requests"
+	Backoff     time.Duration // пауза перед первым повтором, дальше она удваивается
+}
+
+// CheckSpamLimits - ограничения для CheckSpam по умолчанию, свои задаются через Spammer.WithSpamLimits
+// если MaxInFlight не задан, берется HasSpamMaxAsyncRequests на момент запуска
+var CheckSpamLimits = LimitConfig{Retries: 3, Backoff: 50 * time.Millisecond}
+
+func (c LimitConfig) withDefaults(maxInFlight int) LimitConfig {
+	if c.MaxInFlight <= 0 {
+		c.MaxInFlight = maxInFlight
+	}
+	if c.MaxInFlight <= 0 {
+		c.MaxInFlight = 1
+	}
+	return c
+}
+
+// Limiter ограничивает число одновременных запросов и их частоту
+type Limiter struct {
+	slots    chan struct{}
+	interval time.Duration
+	mu       sync.Mutex
+	next     time.Time
+}
+
+func NewLimiter(maxInFlight int, perSecond float64) *Limiter {
+	l := &Limiter{slots: make(chan struct{}, maxInFlight)}
+	if perSecond > 0 {
+		l.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+	return l
+}
+
+// Acquire ждет свободного места и своей очереди по частоте, после запроса нужно вызвать Release
+func (l *Limiter) Acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if l.interval == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(at.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.Release()
+		return ctx.Err()
+	}
+}
+
+func (l *Limiter) Release() {
+	<-l.slots
+}
+
+// Do выполняет f в рамках ограничений. при отмене ctx Do сразу возвращается,
+// но место занято, пока f на самом деле не вернется: запрос в сервис еще идет
+func (l *Limiter) Do(ctx context.Context, f func() error) error {
+	if err := l.Acquire(ctx); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer l.Release()
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Call выполняет f в рамках ограничений и повторяет ее, пока сервис отвечает "too many requests"
+func (l *Limiter) Call(ctx context.Context, cfg LimitConfig, f func() error) error {
+	return retry(ctx, cfg.Retries, cfg.Backoff, isTooManyRequests, func() error {
+		return l.Do(ctx, f)
+	})
+}
+
+func isTooManyRequests(err error) bool {
+	return errors.Is(err, ErrTooManyRequests)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(2, 100)
+	var running, maxRunning int32
+	wg := &sync.WaitGroup{}
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := l.Do(context.Background(), func() error {
+				cur := atomic.AddInt32(&running, 1)
+				for {
+					prev := atomic.LoadInt32(&maxRunning)
+					if cur <= prev || atomic.CompareAndSwapInt32(&maxRunning, prev, cur) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				return nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, maxRunning, int32(2))
+	// 10 запросов при 100 в секунду - не меньше 90мс
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, l.Do(ctx, func() error { return nil }), context.Canceled)
+}
+
+func TestLimiterRetry(t *testing.T) {
+	l := NewLimiter(1, 0)
+	calls := 0
+	err := l.Call(context.Background(), LimitConfig{Retries: 3, Backoff: time.Millisecond}, func() error {
+		calls++
+		if calls < 3 {
+			return ErrTooManyRequests
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	err = l.Call(context.Background(), LimitConfig{Retries: 3, Backoff: time.Millisecond}, func() error {
+		calls++
+		return context.Canceled
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, calls, "повторять стоит только too many requests")
+}
+
+func runCheckSpam(s *Spammer, ids int) []MsgData {
+	in := make(chan MsgID)
+	go func() {
+		defer close(in)
+		for i := 1; i <= ids; i++ {
+			in <- MsgID(i)
+		}
+	}()
+	out, _ := Run(context.Background(), s.CheckSpam(), in)
+	return collect(out)
+}
+
+func TestLimiterHoldsSlot(t *testing.T) {
+	l := NewLimiter(1, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	started := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		errc <- l.Do(ctx, func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+	cancel()
+	assert.ErrorIs(t, <-errc, context.Canceled)
+
+	// вызов еще идет - место занято
+	short, stop := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer stop()
+	assert.ErrorIs(t, l.Acquire(short), context.DeadlineExceeded)
+
+	close(release)
+	assert.NoError(t, l.Do(context.Background(), func() error { return nil }))
+}
+
+func TestCheckSpamLimits(t *testing.T) {
+	defer func(max int) {
+		HasSpamMaxAsyncRequests = max
+	}(HasSpamMaxAsyncRequests)
+
+	// CheckSpam сам подстраивается под новый лимит антиспама
+	HasSpamMaxAsyncRequests = 3
+	stat = Stat{}
+	assert.Len(t, runCheckSpam(defaultSpammer, 12), 12)
+	assert.Equal(t, uint32(0), stat.ErrorHasSpam)
+
+	// лимит больше, чем держит антиспам - запросы повторяются, но ничего не теряется
+	s := defaultSpammer.WithSpamLimits(LimitConfig{MaxInFlight: 5, Retries: 20, Backoff: 10 * time.Millisecond})
+	stat = Stat{}
+	assert.Len(t, runCheckSpam(s, 20), 20)
+	assert.NotZero(t, stat.ErrorHasSpam)
+	assert.Equal(t, uint32(20)+stat.ErrorHasSpam, stat.RunHasSpam)
+}
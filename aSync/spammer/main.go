@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// main - тот самый cat emails.txt | SelectUsers | SelectMessages | CheckSpam | CombineResults
+//
+//	go run . -in emails.txt -format csv
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("spammer", flag.ContinueOnError)
+	inPath := flags.String("in", "-", "файл с имейлами, по одному в строке, - значит stdin")
+	format := flags.String("format", "text", "формат вывода: text, json или csv")
+	batch := flags.Int("batch", GetMessagesMaxUsersBatch, "сколько юзеров отдавать в GetMessages за раз")
+	batchWait := flags.Duration("batch-wait", 0, "сколько ждать, пока наберется пачка для GetMessages, 0 - пока не наберется")
+	concurrency := flags.Int("concurrency", HasSpamMaxAsyncRequests, "сколько запросов к антиспаму одновременно")
+	window := flags.Int("window", 0, "сортировать и печатать результаты окнами по столько штук, 0 - все разом в конце")
+	metricsAddr := flags.String("metrics", "", "адрес для /metrics и /trace, пусто - не поднимать")
+	backend := flags.String("backend", "", "адрес сервисов для HTTPBackend, пусто - симуляция из common.go")
+	aliases := flags.String("aliases", "", "таблица алиасов {\"алиас\": \"имейл\"}: файл или url")
+	normalize := flags.Bool("normalize", false, "приводить имейлы к одному виду: регистр, +tag, точки у gmail")
+	reportPath := flags.String("report", "", "куда записать, какие имейлы склеились в одного юзера, json")
+	checkpointPath := flags.String("checkpoint", "", "файл с контрольными точками: что уже сделано, после падения запуск продолжится с того же места")
+	standIn := flags.String("stand-in", "", "не проверять имейлы, а поднять на этом адресе симуляцию сервисов по http")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *batch <= 0 || *concurrency <= 0 {
+		return fmt.Errorf("batch and concurrency must be positive")
+	}
+	// пределы из common.go - это пределы симуляции, у настоящих сервисов свои
+	if *backend == "" && *batch > GetMessagesMaxUsersBatch {
+		return fmt.Errorf("batch must be from 1 to %d", GetMessagesMaxUsersBatch)
+	}
+	if *backend == "" && *concurrency > HasSpamMaxAsyncRequests {
+		return fmt.Errorf("concurrency must be from 1 to %d", HasSpamMaxAsyncRequests)
+	}
+	if *standIn != "" {
+		return newHTTPServer(*standIn, NewBackendHandler(Simulator{}, Simulator{}, Simulator{})).ListenAndServe()
+	}
+	newWriter, ok := resultWriters[*format]
+	if !ok {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	in := stdin
+	if *inPath != "-" {
+		file, err := os.Open(*inPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	if *metricsAddr != "" {
+		EnableTracing(10000)
+		go func() {
+			if err := ServeMetrics(*metricsAddr); err != nil {
+				log.Printf("metrics: %v", err)
+			}
+		}()
+	}
+
+	spammer := defaultSpammer
+	if *backend != "" {
+		b := NewHTTPBackend(*backend)
+		spammer = NewSpammer(b, b, b)
+	}
+	limits := CheckSpamLimits
+	limits.MaxInFlight = *concurrency
+	spammer = spammer.WithSpamLimits(limits).WithBatchSize(*batch).WithBatchWait(*batchWait)
+	if *window > 0 {
+		spammer = spammer.WithCombine(CombineConfig{Mode: CombineStream, Window: *window})
+	}
+	var registry *AliasRegistry
+	if *normalize || *aliases != "" {
+		rules := NormalizeRules{}
+		if *normalize {
+			rules = DefaultNormalizeRules
+		}
+		table := map[string]string{}
+		if *aliases != "" {
+			var err error
+			if table, err = LoadAliases(context.Background(), *aliases); err != nil {
+				return err
+			}
+		}
+		var err error
+		if registry, err = NewAliasRegistry(rules, table); err != nil {
+			return err
+		}
+		spammer = spammer.WithAliases(registry)
+	}
+	emails := func(emit func(string) bool) error { return readEmails(in, emit) }
+	var checkpoint *Checkpoint
+	if *checkpointPath != "" {
+		// контрольные точки привязаны к списку имейлов, поэтому список читается целиком заранее
+		list := make([]string, 0)
+		if err := readEmails(in, func(email string) bool {
+			list = append(list, email)
+			return true
+		}); err != nil {
+			return err
+		}
+		emails = func(emit func(string) bool) error {
+			for _, email := range list {
+				if !emit(email) {
+					break
+				}
+			}
+			return nil
+		}
+		var err error
+		if checkpoint, err = OpenCheckpoint(*checkpointPath, list, registry); err != nil {
+			return err
+		}
+		defer checkpoint.Close()
+		spammer = spammer.WithCheckpoint(checkpoint)
+	}
+	report := NewMergeReport()
+	spammer = spammer.WithReport(report)
+
+	// по Ctrl+C звенья останавливаются, а то, что уже напечатано, остается
+	// второй Ctrl+C уже просто завершает процесс, если, например, ждем stdin
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+	w := newWriter(stdout)
+	err := RunPipelineContext(ctx, ErrorPolicy{Mode: FailFast},
+		func(ctx context.Context, _ <-chan interface{}, out chan<- interface{}) error {
+			err := emails(func(email string) bool { return send(ctx, out, interface{}(email)) })
+			if err != nil {
+				return err
+			}
+			return ctx.Err()
+		},
+		Then(spammer.Results(), Stage[MsgData, struct{}](func(ctx context.Context, in <-chan MsgData, _ chan<- struct{}) error {
+			// после ошибки вход все равно дочитываем, иначе встанут звенья до нас
+			var err error
+			for res := range in {
+				if err == nil {
+					err = w.write(res)
+				}
+				// напечатанное после падения печатать уже не надо
+				if err == nil && checkpoint != nil {
+					err = checkpoint.MarkEmitted(res.ID)
+				}
+			}
+			if err != nil {
+				return err
+			}
+			return w.flush()
+		})).Any(),
+	)
+	if err != nil {
+		return err
+	}
+	if *reportPath != "" {
+		return writeReport(*reportPath, report.Merged())
+	}
+	return nil
+}
+
+func writeReport(path string, groups []MergeGroup) error {
+	data, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// readEmails отдает непустые строки, # в начале строки - комментарий
+// если emit вернул false, чтение прекращается
+func readEmails(in io.Reader, emit func(string) bool) error {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		email := strings.TrimSpace(scanner.Text())
+		if email == "" || strings.HasPrefix(email, "#") {
+			continue
+		}
+		if !emit(email) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// resultWriter пишет результаты по одному, как только они готовы
+type resultWriter interface {
+	write(res MsgData) error
+	flush() error
+}
+
+var resultWriters = map[string]func(io.Writer) resultWriter{
+	"text": func(out io.Writer) resultWriter { return &textWriter{out} },
+	"json": func(out io.Writer) resultWriter { return &jsonWriter{json.NewEncoder(out)} },
+	"csv":  func(out io.Writer) resultWriter { return &csvWriter{w: csv.NewWriter(out)} },
+}
+
+type textWriter struct {
+	out io.Writer
+}
+
+func (w *textWriter) write(res MsgData) error {
+	_, err := fmt.Fprintln(w.out, formatMsg(res))
+	return err
+}
+
+func (w *textWriter) flush() error {
+	return nil
+}
+
+// jsonWriter пишет по объекту в строке
+type jsonWriter struct {
+	enc *json.Encoder
+}
+
+func (w *jsonWriter) write(res MsgData) error {
+	return w.enc.Encode(struct {
+		MsgID   MsgID `json:"msg_id"`
+		HasSpam bool  `json:"has_spam"`
+	}{res.ID, res.HasSpam})
+}
+
+func (w *jsonWriter) flush() error {
+	return nil
+}
+
+// csvWriter пишет заголовок перед первой строкой, а если результатов нет - в flush
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (w *csvWriter) writeRow(row ...string) error {
+	if !w.header {
+		w.header = true
+		if err := w.w.Write([]string{"has_spam", "msg_id"}); err != nil {
+			return err
+		}
+	}
+	if row != nil {
+		if err := w.w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func (w *csvWriter) write(res MsgData) error {
+	return w.writeRow(strconv.FormatBool(res.HasSpam), strconv.FormatUint(uint64(res.ID), 10))
+}
+
+func (w *csvWriter) flush() error {
+	return w.writeRow()
+}
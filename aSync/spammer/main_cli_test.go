@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteResults(t *testing.T) {
+	results := []MsgData{{ID: 12, HasSpam: true}, {ID: 3}}
+	expected := map[string]string{
+		"text": "true 12\nfalse 3\n",
+		"json": "{\"msg_id\":12,\"has_spam\":true}\n{\"msg_id\":3,\"has_spam\":false}\n",
+		"csv":  "has_spam,msg_id\ntrue,12\nfalse,3\n",
+	}
+	for format, want := range expected {
+		buf := &bytes.Buffer{}
+		w := resultWriters[format](buf)
+		for _, res := range results {
+			require.NoError(t, w.write(res))
+		}
+		require.NoError(t, w.flush())
+		assert.Equal(t, want, buf.String(), format)
+	}
+
+	// заголовок csv есть и без результатов
+	buf := &bytes.Buffer{}
+	require.NoError(t, resultWriters["csv"](buf).flush())
+	assert.Equal(t, "has_spam,msg_id\n", buf.String())
+}
+
+func TestReadEmails(t *testing.T) {
+	res := make([]string, 0)
+	err := readEmails(strings.NewReader("a@mail.ru\n\n# комментарий\n  b@mail.ru  \nc@mail.ru\n"), func(email string) bool {
+		res = append(res, email)
+		return len(res) < 2
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a@mail.ru", "b@mail.ru"}, res)
+}
+
+func TestRunCLI(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "emails.txt")
+	require.NoError(t, os.WriteFile(path, []byte("batman@mail.ru\nbruce.wayne@mail.ru\nharry.dubois@mail.ru\n"), 0o600))
+
+	text := &bytes.Buffer{}
+	stat = Stat{}
+	require.NoError(t, run([]string{"-in", path}, nil, text))
+	// неполных пачек по умолчанию нет: два юзера - один вызов GetMessages
+	assert.Equal(t, uint32(2), stat.GetMessagesTotalUsers)
+	assert.Equal(t, uint32(1), stat.RunGetMessages)
+	assert.Equal(t, strings.Join([]string{
+		"true 9323185346293974544",
+		"true 12386730660396758454",
+		"true 12728377754914798838",
+		"true 14107154567229229487",
+		"true 17087986564527251681",
+		"false 59892029605752939",
+		"false 12975933273041759035",
+		"false 14498495926778052146",
+		"false 15262116397886015961",
+		"false 15728889559763622673",
+	}, "\n")+"\n", text.String())
+
+	csvOut := &bytes.Buffer{}
+	input := strings.NewReader("batman@mail.ru\nbruce.wayne@mail.ru\nharry.dubois@mail.ru\n")
+	require.NoError(t, run([]string{"-format", "csv", "-batch", "1", "-concurrency", "2"}, input, csvOut))
+	assert.Equal(t, "has_spam,msg_id\n"+strings.ReplaceAll(text.String(), " ", ","), csvOut.String())
+
+	// окнами по одному - те же результаты, но в порядке готовности
+	streamed := &bytes.Buffer{}
+	require.NoError(t, run([]string{"-in", path, "-window", "1"}, nil, streamed))
+	assert.ElementsMatch(t, strings.Split(text.String(), "\n"), strings.Split(streamed.String(), "\n"))
+
+	// ошибка сервиса - ошибка всего запуска
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	assert.Error(t, run([]string{"-backend", srv.URL}, strings.NewReader("batman@mail.ru\n"), &bytes.Buffer{}))
+
+	// пределы симуляции к настоящим сервисам не относятся
+	standIn := httptest.NewServer(NewBackendHandler(Simulator{}, Simulator{}, Simulator{}))
+	defer standIn.Close()
+	remote := &bytes.Buffer{}
+	args := []string{"-in", path, "-backend", standIn.URL,
+		"-batch", strconv.Itoa(GetMessagesMaxUsersBatch + 1), "-concurrency", strconv.Itoa(HasSpamMaxAsyncRequests + 1)}
+	require.NoError(t, run(args, nil, remote))
+	assert.Equal(t, text.String(), remote.String())
+
+	assert.Error(t, run([]string{"-format", "xml"}, nil, &bytes.Buffer{}))
+	assert.Error(t, run([]string{"-batch", "0"}, nil, &bytes.Buffer{}))
+	assert.Error(t, run([]string{"-backend", standIn.URL, "-concurrency", "0"}, nil, &bytes.Buffer{}))
+	assert.Error(t, run([]string{"-batch", strconv.Itoa(GetMessagesMaxUsersBatch + 1)}, nil, &bytes.Buffer{}))
+	assert.Error(t, run([]string{"-concurrency", strconv.Itoa(HasSpamMaxAsyncRequests + 1)}, nil, &bytes.Buffer{}))
+}
@@ -0,0 +1,571 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// границы корзин гистограммы времени обработки одного значения, в секундах
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// stageMetrics - метрики звена, копятся за все запуски
+type stageMetrics struct {
+	name     string
+	in       uint64
+	out      uint64
+	errs     uint64
+	inflight int64
+
+	mu      sync.Mutex
+	buckets []uint64
+	sum     float64
+	count   uint64
+	queues  map[int]func() int
+	queueID int
+}
+
+func (m *stageMetrics) observe(d time.Duration) {
+	if m == nil {
+		return
+	}
+	sec := d.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, le := range latencyBuckets {
+		if sec <= le {
+			m.buckets[i]++
+		}
+	}
+	m.sum += sec
+	m.count++
+}
+
+func (m *stageMetrics) failed() {
+	if m != nil {
+		atomic.AddUint64(&m.errs, 1)
+	}
+}
+
+func (m *stageMetrics) begin() {
+	if m != nil {
+		atomic.AddInt64(&m.inflight, 1)
+	}
+}
+
+func (m *stageMetrics) end() {
+	if m != nil {
+		atomic.AddInt64(&m.inflight, -1)
+	}
+}
+
+// watchQueue учитывает входной канал звена в глубине очереди, пока звено работает
+func (m *stageMetrics) watchQueue(depth func() int) func() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queueID++
+	id := m.queueID
+	m.queues[id] = depth
+	return func() {
+		m.mu.Lock()
+		delete(m.queues, id)
+		m.mu.Unlock()
+	}
+}
+
+func (m *stageMetrics) queueDepth() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := 0
+	for _, depth := range m.queues {
+		res += depth()
+	}
+	return res
+}
+
+type metricsRegistry struct {
+	mu     sync.Mutex
+	stages map[string]*stageMetrics
+}
+
+var metrics = &metricsRegistry{stages: make(map[string]*stageMetrics)}
+
+func (r *metricsRegistry) stage(name string) *stageMetrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.stages[name]
+	if !ok {
+		m = &stageMetrics{name: name, buckets: make([]uint64, len(latencyBuckets)), queues: make(map[int]func() int)}
+		r.stages[name] = m
+	}
+	return m
+}
+
+func (r *metricsRegistry) sorted() []*stageMetrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := make([]*stageMetrics, 0, len(r.stages))
+	for _, m := range r.stages {
+		res = append(res, m)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].name < res[j].name })
+	return res
+}
+
+type stageKey struct{}
+
+func stageFrom(ctx context.Context) *stageMetrics {
+	m, _ := ctx.Value(stageKey{}).(*stageMetrics)
+	return m
+}
+
+// Named дает звену имя, под ним звено видно в метриках и трассировке
+func Named[In, Out any](name string, s Stage[In, Out]) Stage[In, Out] {
+	return func(ctx context.Context, in <-chan In, out chan<- Out) error {
+		m := metrics.stage(name)
+		links := linksFrom(ctx)
+		// у звеньев внутри свои очереди ID: вход и выход этого звена
+		inner := traceLinks{newTraceLink(), newTraceLink()}
+		ctx = context.WithValue(ctx, stageKey{}, m)
+		ctx = context.WithValue(ctx, tracedKey{}, inner.in != nil)
+		ctx = withLinks(ctx, inner.in, inner.out)
+		counted := make(chan In, limitsFrom(ctx).StageBuffers[name])
+		// held - значение, которое уже прочитано, но звено его еще не взяло
+		held := int64(0)
+		defer m.watchQueue(func() int { return len(in) + len(counted) + int(atomic.LoadInt64(&held)) })()
+
+		go func() {
+			defer close(counted)
+			for {
+				v, id, ok := recvTraced(ctx, links.in, in)
+				if !ok {
+					return
+				}
+				atomic.AddUint64(&m.in, 1)
+				atomic.StoreInt64(&held, 1)
+				ok = sendTraced(ctx, inner.in, counted, v, id)
+				atomic.StoreInt64(&held, 0)
+				if !ok {
+					return
+				}
+			}
+		}()
+		res, errc := Run(ctx, s, counted)
+		for v := range res {
+			if sendTraced(ctx, links.out, out, v, inner.out.pop()) {
+				atomic.AddUint64(&m.out, 1)
+			}
+		}
+		return <-errc
+	}
+}
+
+// measureCmd запускает cmd из RunPipeline под именем name: считает входы, выходы
+// и очередь на входе. время на одно значение у cmd не узнать, его считают только звенья из Named
+func measureCmd(name string, c cmd, in, out chan interface{}) {
+	m := metrics.stage(name)
+	cin := make(chan interface{})
+	cout := make(chan interface{})
+	// значения идут через cin и cout в том же порядке, очередь trace ID у них общая
+	if link := cmdLink(in); link != nil {
+		registerCmdLink(cin, link)
+		defer unregisterCmdLink(cin)
+	}
+	if link := cmdLink(out); link != nil {
+		registerCmdLink(cout, link)
+		defer unregisterCmdLink(cout)
+	}
+	held := int64(0)
+	defer m.watchQueue(func() int { return len(in) + int(atomic.LoadInt64(&held)) })()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(cin)
+		for v := range in {
+			atomic.AddUint64(&m.in, 1)
+			atomic.StoreInt64(&held, 1)
+			select {
+			case cin <- v:
+				atomic.StoreInt64(&held, 0)
+			case <-done:
+				// cmd вернулся, не дочитав вход, остальное выкидываем, чтобы не встали звенья до него
+				atomic.StoreInt64(&held, 0)
+				for range in {
+				}
+				return
+			}
+		}
+	}()
+	go func() {
+		defer close(cout)
+		defer close(done)
+		c(cin, cout)
+	}()
+	for v := range cout {
+		out <- v
+		atomic.AddUint64(&m.out, 1)
+	}
+}
+
+// writePrometheus пишет метрики в текстовом формате Prometheus
+func (r *metricsRegistry) writePrometheus(w io.Writer) error {
+	stages := r.sorted()
+	lines := make([]string, 0)
+	metric := func(name, kind, help string, value func(m *stageMetrics) string) {
+		lines = append(lines, "# HELP "+name+" "+help, "# TYPE "+name+" "+kind)
+		for _, m := range stages {
+			lines = append(lines, fmt.Sprintf("%s{stage=%q} %s", name, m.name, value(m)))
+		}
+	}
+	metric("spammer_stage_items_in_total", "counter", "Items read by the stage.", func(m *stageMetrics) string {
+		return strconv.FormatUint(atomic.LoadUint64(&m.in), 10)
+	})
+	metric("spammer_stage_items_out_total", "counter", "Items written by the stage.", func(m *stageMetrics) string {
+		return strconv.FormatUint(atomic.LoadUint64(&m.out), 10)
+	})
+	metric("spammer_stage_errors_total", "counter", "Errors in the stage.", func(m *stageMetrics) string {
+		return strconv.FormatUint(atomic.LoadUint64(&m.errs), 10)
+	})
+	metric("spammer_stage_inflight", "gauge", "Items being processed right now.", func(m *stageMetrics) string {
+		return strconv.FormatInt(atomic.LoadInt64(&m.inflight), 10)
+	})
+	metric("spammer_stage_queue_depth", "gauge", "Items waiting in the stage input.", func(m *stageMetrics) string {
+		return strconv.Itoa(m.queueDepth())
+	})
+
+	name := "spammer_stage_latency_seconds"
+	lines = append(lines, "# HELP "+name+" Time to process one item.", "# TYPE "+name+" histogram")
+	for _, m := range stages {
+		m.mu.Lock()
+		for i, le := range latencyBuckets {
+			lines = append(lines, fmt.Sprintf("%s_bucket{stage=%q,le=%q} %d", name, m.name, strconv.FormatFloat(le, 'g', -1, 64), m.buckets[i]))
+		}
+		lines = append(lines,
+			fmt.Sprintf("%s_bucket{stage=%q,le=\"+Inf\"} %d", name, m.name, m.count),
+			fmt.Sprintf("%s_sum{stage=%q} %s", name, m.name, strconv.FormatFloat(m.sum, 'g', -1, 64)),
+			fmt.Sprintf("%s_count{stage=%q} %d", name, m.name, m.count))
+		m.mu.Unlock()
+	}
+
+	lines = append(lines, "# HELP spammer_pipeline_item_goroutines Goroutines started for single items.",
+		"# TYPE spammer_pipeline_item_goroutines gauge",
+		fmt.Sprintf("spammer_pipeline_item_goroutines %d", atomic.LoadInt64(&itemGoroutines)))
+
+	lines = append(lines, "# HELP spammer_calls_total Calls of the simulated services.", "# TYPE spammer_calls_total counter")
+	for _, c := range []struct {
+		name  string
+		value *uint32
+	}{
+		{"GetUser", &stat.RunGetUser},
+		{"GetMessages", &stat.RunGetMessages},
+		{"HasSpam", &stat.RunHasSpam},
+	} {
+		lines = append(lines, fmt.Sprintf("spammer_calls_total{func=%q} %d", c.name, atomic.LoadUint32(c.value)))
+	}
+	lines = append(lines, "# HELP spammer_user_cache_total User cache lookups.", "# TYPE spammer_user_cache_total counter")
+	for _, c := range []struct {
+		name  string
+		value *uint32
+	}{
+		{"hit", &cacheStat.Hits},
+		{"miss", &cacheStat.Misses},
+		{"coalesced", &cacheStat.Coalesced},
+	} {
+		lines = append(lines, fmt.Sprintf("spammer_user_cache_total{result=%q} %d", c.name, atomic.LoadUint32(c.value)))
+	}
+
+	for _, line := range lines {
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Span - обработка одного значения в звене: что пришло и что из него получилось
+// Parents - записи, в которых получилось входное значение, у входа конвейера их нет
+type Span struct {
+	ID       uint64        `json:"id"`
+	Parents  []uint64      `json:"parents,omitempty"`
+	Stage    string        `json:"stage"`
+	In       string        `json:"in"`
+	Out      []string      `json:"out"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Err      string        `json:"err,omitempty"`
+}
+
+// tracer хранит последние size записей
+type tracer struct {
+	mu     sync.Mutex
+	size   int
+	spans  []Span
+	next   int
+	lastID uint64
+}
+
+var tracing = &tracer{}
+
+// EnableTracing включает трассировку, храним последние size записей. 0 - выключить
+func EnableTracing(size int) {
+	tracing.mu.Lock()
+	defer tracing.mu.Unlock()
+	tracing.size = size
+	tracing.spans = make([]Span, 0, size)
+	tracing.next = 0
+}
+
+func (t *tracer) enabled() bool {
+	return t.capacity() > 0
+}
+
+func (t *tracer) capacity() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.size
+}
+
+func (t *tracer) newID() uint64 {
+	return atomic.AddUint64(&t.lastID, 1)
+}
+
+func (t *tracer) record(span Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.size == 0 {
+		return
+	}
+	if len(t.spans) < t.size {
+		t.spans = append(t.spans, span)
+		return
+	}
+	t.spans[t.next] = span
+	t.next = (t.next + 1) % t.size
+}
+
+func (t *tracer) all() []Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := make([]Span, 0, len(t.spans))
+	res = append(res, t.spans[t.next:]...)
+	return append(res, t.spans[:t.next]...)
+}
+
+// path - путь входного значения по звеньям: его обработка, потом обработка того, что из него получилось, и так далее
+func (t *tracer) path(item string) []Span {
+	return t.follow(func(span Span) bool { return len(span.Parents) == 0 && span.In == item })
+}
+
+// pathFrom - запись id и все, что получилось из ее результатов
+func (t *tracer) pathFrom(id uint64) []Span {
+	return t.follow(func(span Span) bool { return span.ID == id })
+}
+
+func (t *tracer) follow(start func(span Span) bool) []Span {
+	spans := t.all()
+	children := make(map[uint64][]int)
+	queue := make([]int, 0)
+	for i, span := range spans {
+		for _, parent := range span.Parents {
+			children[parent] = append(children[parent], i)
+		}
+		if start(span) {
+			queue = append(queue, i)
+		}
+	}
+	res := make([]Span, 0)
+	seen := make(map[int]bool)
+	for len(queue) != 0 {
+		i := queue[0]
+		queue = queue[1:]
+		if seen[i] {
+			continue
+		}
+		seen[i] = true
+		res = append(res, spans[i])
+		queue = append(queue, children[spans[i].ID]...)
+	}
+	return res
+}
+
+// traceLink - trace ID значений одного потока между звеньями, в том же порядке, что и значения.
+// ID не лежит в самом значении, типы каналов у звеньев свои. поэтому кто пишет в поток,
+// кладет ID и отправляет значение под sendMu, а кто читает - берет значение и ID под recvMu:
+// так ID не разъезжаются со значениями, даже если пишут и читают несколько горутин.
+// звено, которое читает или пишет поток мимо recvTraced и sendTraced, ломает этот порядок
+type traceLink struct {
+	mu     sync.Mutex
+	ids    []uint64
+	recvMu sync.Mutex
+	sendMu sync.Mutex
+}
+
+// newTraceLink - очередь ID для нового потока, без трассировки nil
+func newTraceLink() *traceLink {
+	if !tracing.enabled() {
+		return nil
+	}
+	return &traceLink{}
+}
+
+func (l *traceLink) push(id uint64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.ids = append(l.ids, id)
+	l.mu.Unlock()
+}
+
+// pop - ID следующего значения, 0 - значение пришло не из звена с трассировкой
+func (l *traceLink) pop() uint64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.ids) == 0 {
+		return 0
+	}
+	id := l.ids[0]
+	l.ids = l.ids[1:]
+	return id
+}
+
+// recvTraced - recv, который вместе со значением забирает его trace ID из link
+func recvTraced[T any](ctx context.Context, link *traceLink, in <-chan T) (T, uint64, bool) {
+	if link == nil {
+		v, ok := recv(ctx, in)
+		return v, 0, ok
+	}
+	link.recvMu.Lock()
+	defer link.recvMu.Unlock()
+	v, ok := recv(ctx, in)
+	if !ok {
+		return v, 0, false
+	}
+	return v, link.pop(), true
+}
+
+// sendTraced - send, который кладет в link trace ID значения
+func sendTraced[T any](ctx context.Context, link *traceLink, out chan<- T, v T, id uint64) bool {
+	if link == nil {
+		return send(ctx, out, v)
+	}
+	link.sendMu.Lock()
+	defer link.sendMu.Unlock()
+	link.push(id)
+	return send(ctx, out, v)
+}
+
+// parentIDs - Parents записи о значении, которое пришло с ID id
+func parentIDs(id uint64) []uint64 {
+	if id == 0 {
+		return nil
+	}
+	return []uint64{id}
+}
+
+type linksKey struct{}
+
+// traceLinks - потоки, которыми звено соединено с соседями
+type traceLinks struct {
+	in, out *traceLink
+}
+
+func linksFrom(ctx context.Context) traceLinks {
+	links, _ := ctx.Value(linksKey{}).(traceLinks)
+	return links
+}
+
+func withLinks(ctx context.Context, in, out *traceLink) context.Context {
+	return context.WithValue(ctx, linksKey{}, traceLinks{in, out})
+}
+
+// у cmd нет контекста, очереди ID для каналов RunPipeline ищутся по самим каналам
+var cmdLinks = struct {
+	sync.Mutex
+	links map[chan interface{}]*traceLink
+}{links: make(map[chan interface{}]*traceLink)}
+
+func registerCmdLink(ch chan interface{}, link *traceLink) {
+	cmdLinks.Lock()
+	cmdLinks.links[ch] = link
+	cmdLinks.Unlock()
+}
+
+func unregisterCmdLink(ch chan interface{}) {
+	cmdLinks.Lock()
+	delete(cmdLinks.links, ch)
+	cmdLinks.Unlock()
+}
+
+func cmdLink(ch chan interface{}) *traceLink {
+	cmdLinks.Lock()
+	defer cmdLinks.Unlock()
+	return cmdLinks.links[ch]
+}
+
+type tracedKey struct{}
+
+// tracedFrom - пишет ли звено, в котором работает ctx, записи трассировки
+func tracedFrom(ctx context.Context) bool {
+	traced, _ := ctx.Value(tracedKey{}).(bool)
+	return traced
+}
+
+// metricsHandler: /metrics - метрики для Prometheus, /trace - последние записи трассировки,
+// /trace?item=... - путь входного значения, /trace?id=... - путь от записи с этим ID
+func metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := metrics.writePrometheus(w); err != nil {
+			log.Printf("write metrics: %v", err)
+		}
+	})
+	mux.HandleFunc("/trace", func(w http.ResponseWriter, r *http.Request) {
+		spans := tracing.all()
+		if item := r.URL.Query().Get("item"); item != "" {
+			spans = tracing.path(item)
+		}
+		if id := r.URL.Query().Get("id"); id != "" {
+			n, err := strconv.ParseUint(id, 10, 64)
+			if err != nil {
+				http.Error(w, "bad id", http.StatusBadRequest)
+				return
+			}
+			spans = tracing.pathFrom(n)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(spans); err != nil {
+			log.Printf("write trace: %v", err)
+		}
+	})
+	return mux
+}
+
+// ServeMetrics поднимает http сервер с метриками и трассировкой
+func ServeMetrics(addr string) error {
+	return newHTTPServer(addr, metricsHandler()).ListenAndServe()
+}
+
+// newHTTPServer - сервер со сроками, чтобы медленный клиент не держал соединение вечно
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       time.Minute,
+	}
+}
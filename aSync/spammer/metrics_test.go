@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// resetStages забывает метрики звеньев, реестр общий и копит их между запусками тестов
+func resetStages(names ...string) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	for _, name := range names {
+		delete(metrics.stages, name)
+	}
+}
+
+func TestStageMetrics(t *testing.T) {
+	name := "test_" + t.Name()
+	resetStages(name)
+	half := Named(name, FlatMap(2, func(ctx context.Context, v int, emit func(int)) error {
+		if v%2 != 0 {
+			return errors.New("odd")
+		}
+		emit(v / 2)
+		return nil
+	}))
+	out, errc := Run(context.Background(), half, sendAll(1, 2, 3, 4, 5, 6))
+	assert.Len(t, collect(out), 3)
+	assert.NoError(t, <-errc)
+
+	m := metrics.stage(name)
+	assert.EqualValues(t, 6, m.in)
+	assert.EqualValues(t, 3, m.out)
+	assert.EqualValues(t, 3, m.errs)
+	assert.EqualValues(t, 6, m.count)
+	assert.EqualValues(t, 0, m.inflight)
+	assert.Equal(t, 0, m.queueDepth())
+
+	buf := &strings.Builder{}
+	require.NoError(t, metrics.writePrometheus(buf))
+	text := buf.String()
+	for _, line := range []string{
+		`spammer_stage_items_in_total{stage="` + name + `"} 6`,
+		`spammer_stage_items_out_total{stage="` + name + `"} 3`,
+		`spammer_stage_errors_total{stage="` + name + `"} 3`,
+		`spammer_stage_latency_seconds_bucket{stage="` + name + `",le="+Inf"} 6`,
+		`spammer_stage_latency_seconds_count{stage="` + name + `"} 6`,
+		"# TYPE spammer_stage_latency_seconds histogram",
+	} {
+		assert.Contains(t, text, line+"\n")
+	}
+}
+
+func TestTrace(t *testing.T) {
+	EnableTracing(100)
+	defer EnableTracing(0)
+
+	double := Named("test_double", Map(1, func(ctx context.Context, v int) (int, error) { return v * 2, nil }))
+	split := Named("test_split", FlatMap(1, func(ctx context.Context, v int, emit func(int)) error {
+		emit(v + 1)
+		emit(v - 1)
+		return nil
+	}))
+	out, errc := Run(context.Background(), Then(double, split), sendAll(5, 7))
+	collect(out)
+	assert.NoError(t, <-errc)
+
+	path := tracing.path("5")
+	require.Len(t, path, 2)
+	assert.Equal(t, "test_double", path[0].Stage)
+	assert.Equal(t, []string{"10"}, path[0].Out)
+	assert.Equal(t, "test_split", path[1].Stage)
+	assert.Equal(t, []string{"11", "9"}, path[1].Out)
+
+	srv := httptest.NewServer(metricsHandler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/trace?item=7")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	spans := make([]Span, 0)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&spans))
+	require.Len(t, spans, 2)
+	assert.Equal(t, "14", spans[1].In)
+
+	resp, err = http.Get(srv.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestTraceSameValues(t *testing.T) {
+	EnableTracing(100)
+	defer EnableTracing(0)
+
+	square := Named("test_square", Map(1, func(ctx context.Context, v int) (int, error) { return v * v, nil }))
+	inc := Named("test_inc", Map(1, func(ctx context.Context, v int) (int, error) { return v + 1, nil }))
+	out, errc := Run(context.Background(), Then(square, inc), sendAll(1, -1))
+	assert.Equal(t, []int{2, 2}, collect(out))
+	assert.NoError(t, <-errc)
+
+	// оба входа дают 1, но у каждого свое продолжение
+	for _, item := range []string{"1", "-1"} {
+		path := tracing.path(item)
+		require.Len(t, path, 2, item)
+		assert.Equal(t, "test_square", path[0].Stage)
+		assert.Equal(t, []uint64{path[0].ID}, path[1].Parents)
+		assert.Equal(t, path, tracing.pathFrom(path[0].ID))
+	}
+}
+
+func TestTraceReordered(t *testing.T) {
+	EnableTracing(100)
+	defer EnableTracing(0)
+
+	// 1 обрабатывается дольше и уходит вторым, пачки - не сравнимые значения
+	wrap := Named("test_wrap", Map(2, func(ctx context.Context, v int) ([]int, error) {
+		if v == 1 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return []int{v}, nil
+	}))
+	sum := Named("test_sum", Batch(2, 0, func(ctx context.Context, batch [][]int, emit func(int)) error {
+		res := 0
+		for _, v := range batch {
+			res += v[0]
+		}
+		emit(res)
+		return nil
+	}))
+	out, errc := Run(context.Background(), Then(wrap, sum), sendAll(1, 2))
+	assert.Equal(t, []int{3}, collect(out))
+	assert.NoError(t, <-errc)
+
+	parents := make([]uint64, 0)
+	for _, item := range []string{"1", "2"} {
+		path := tracing.path(item)
+		require.Len(t, path, 2, item)
+		assert.Equal(t, []string{"[" + item + "]"}, path[0].Out)
+		assert.Equal(t, "[[2] [1]]", path[1].In)
+		parents = append(parents, path[0].ID)
+	}
+	assert.ElementsMatch(t, parents, tracing.path("1")[1].Parents)
+}
+
+func TestRunPipelineMetrics(t *testing.T) {
+	EnableTracing(100)
+	defer EnableTracing(0)
+
+	source := func(in, out chan interface{}) {
+		for _, v := range []int{3, 4} {
+			out <- v
+		}
+	}
+	// в метриках cmd называются по своей функции, как SelectUsers и остальные звенья задания
+	double := func(in, out chan interface{}) {
+		Named("test_cmd_double", Map(1, func(ctx context.Context, v int) (int, error) { return v * 2, nil })).Cmd()(in, out)
+	}
+	split := func(in, out chan interface{}) {
+		Named("test_cmd_split", FlatMap(1, func(ctx context.Context, v int, emit func(int)) error {
+			emit(v + 1)
+			emit(v - 1)
+			return nil
+		})).Cmd()(in, out)
+	}
+	resetStages("RunPipeline/"+cmdName(source), "RunPipeline/"+cmdName(double), "RunPipeline/"+cmdName(split))
+	RunPipeline(source, double, split)
+
+	m := metrics.stage("RunPipeline/" + cmdName(source))
+	assert.EqualValues(t, 0, m.in)
+	assert.EqualValues(t, 2, m.out)
+	m = metrics.stage("RunPipeline/" + cmdName(split))
+	assert.EqualValues(t, 2, m.in)
+	assert.EqualValues(t, 4, m.out)
+	assert.Equal(t, 0, m.queueDepth())
+
+	// ID идут и через каналы RunPipeline
+	path := tracing.path("3")
+	require.Len(t, path, 2)
+	assert.Equal(t, "test_cmd_split", path[1].Stage)
+	assert.Equal(t, []string{"7", "5"}, path[1].Out)
+}
+
+func TestQueueDepth(t *testing.T) {
+	name := "test_" + t.Name()
+	resetStages(name)
+	release := make(chan struct{})
+	slow := Named(name, FlatMap(1, func(ctx context.Context, v int, emit func(int)) error {
+		<-release
+		emit(v)
+		return nil
+	}))
+	in := make(chan int, 5)
+	for i := 0; i < 5; i++ {
+		in <- i
+	}
+	close(in)
+	out, errc := Run(context.Background(), slow, in)
+
+	// одно в работе, одно прочитано и ждет, три в буфере входа
+	m := metrics.stage(name)
+	assert.Eventually(t, func() bool { return m.queueDepth() == 4 }, time.Second, time.Millisecond)
+	close(release)
+	assert.Len(t, collect(out), 5)
+	assert.NoError(t, <-errc)
+	assert.Equal(t, 0, m.queueDepth())
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlatMapOrdered(t *testing.T) {
+	input := make([]int, 200)
+	for i := range input {
+		input[i] = i
+	}
+	for _, workers := range []int{0, 1, 7} {
+		twice := FlatMapOrdered(workers, func(ctx context.Context, v int, emit func(int)) error {
+			time.Sleep(time.Duration(rand.Intn(300)) * time.Microsecond) //nolint: gosec
+			emit(v)
+			emit(v)
+			return nil
+		})
+		out, errc := Run(context.Background(), twice, sendAll(input...))
+		res := collect(out)
+		require.NoError(t, <-errc)
+		require.Len(t, res, 2*len(input), "workers %d", workers)
+		for i, v := range res {
+			assert.Equal(t, i/2, v, "workers %d", workers)
+		}
+	}
+}
+
+func TestMaxGoroutines(t *testing.T) {
+	limits := DefaultPipelineLimits()
+	limits.MaxGoroutines = 3
+	ctx := WithPipelineLimits(context.Background(), limits)
+
+	var running, maxRunning int32
+	slow := FlatMap(0, func(ctx context.Context, v int, emit func(int)) error {
+		cur := atomic.AddInt32(&running, 1)
+		for {
+			prev := atomic.LoadInt32(&maxRunning)
+			if cur <= prev || atomic.CompareAndSwapInt32(&maxRunning, prev, cur) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		emit(v)
+		return nil
+	})
+	out, errc := Run(ctx, slow, sendAll(1, 2, 3, 4, 5, 6, 7, 8, 9, 10))
+	assert.Len(t, collect(out), 10)
+	assert.NoError(t, <-errc)
+	// 3 горутины и еще одно значение в читающей горутине
+	assert.LessOrEqual(t, maxRunning, int32(4))
+	assert.Zero(t, atomic.LoadInt64(&itemGoroutines))
+}
+
+func TestPipelineBuffer(t *testing.T) {
+	limits := DefaultPipelineLimits()
+	limits.Buffer = 5
+
+	var sent int32
+	release := make(chan struct{})
+	received := 0
+	err := RunPipelineContext(WithPipelineLimits(context.Background(), limits), ErrorPolicy{},
+		func(ctx context.Context, in <-chan interface{}, out chan<- interface{}) error {
+			for i := 0; i < 20; i++ {
+				if !send(ctx, out, interface{}(i)) {
+					return ctx.Err()
+				}
+				atomic.AddInt32(&sent, 1)
+			}
+			return nil
+		},
+		func(ctx context.Context, in <-chan interface{}, out chan<- interface{}) error {
+			<-release
+			for range in {
+				received++
+			}
+			return nil
+		},
+		func(ctx context.Context, in <-chan interface{}, out chan<- interface{}) error {
+			// пока второе звено стоит, первое успевает положить в буфер не больше 5 значений
+			assert.Eventually(t, func() bool { return atomic.LoadInt32(&sent) == 5 }, time.Second, time.Millisecond)
+			time.Sleep(20 * time.Millisecond)
+			assert.Equal(t, int32(5), atomic.LoadInt32(&sent))
+			close(release)
+			return nil
+		},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 20, received)
+}
+
+func TestLargeInput(t *testing.T) {
+	ctx := WithPipelineLimits(context.Background(), PipelineLimits{Buffer: 64, StageBuffers: map[string]int{"test_large": 256}, MaxGoroutines: 64, OrderWindow: 128})
+
+	n := 1 << 20
+	if testing.Short() {
+		n = 1 << 12
+	}
+	in := make(chan int, 1024)
+	go func() {
+		defer close(in)
+		for i := 0; i < n; i++ {
+			in <- i
+		}
+	}()
+	double := Named("test_large", FlatMapOrdered(8, func(ctx context.Context, v int, emit func(int)) error {
+		emit(v * 2)
+		return nil
+	}))
+	inc := FlatMapOrdered(4, func(ctx context.Context, v int, emit func(int)) error {
+		emit(v + 1)
+		return nil
+	})
+	out, errc := Run(ctx, Then(double, inc), in)
+
+	cnt := 0
+	for v := range out {
+		if v != 2*cnt+1 {
+			t.Fatalf("item %d: got %d", cnt, v)
+		}
+		cnt++
+	}
+	require.NoError(t, <-errc)
+	assert.Equal(t, n, cnt)
+}
+
+func TestSelectUsersOrdered(t *testing.T) {
+	limits := DefaultPipelineLimits()
+	limits.Ordered = true
+	ctx := WithPipelineLimits(context.Background(), limits)
+
+	f := &fakeBackend{delay: time.Millisecond}
+	emails := []string{"f@x", "e@x", "d@x", "c@x", "b@x", "a@x", "g@x", "h@x"}
+	out, errc := Run(ctx, NewSpammer(f, f, f).SelectUsers(), sendAll(emails...))
+	res := make([]string, 0)
+	for u := range out {
+		res = append(res, u.Email)
+	}
+	require.NoError(t, <-errc)
+	assert.Equal(t, emails, res)
+}
+
+// usersByLetter - юзер по первой букве имейла, первый имейл ищется дольше всех
+type usersByLetter struct{}
+
+func (usersByLetter) GetUser(ctx context.Context, email string) (User, error) {
+	if email == "a1@x" {
+		time.Sleep(30 * time.Millisecond)
+	}
+	return User{ID: uint64(email[0]), Email: email}, nil
+}
+
+func TestSelectUsersOrderedDuplicates(t *testing.T) {
+	limits := DefaultPipelineLimits()
+	limits.Ordered = true
+	ctx := WithPipelineLimits(context.Background(), limits)
+
+	// a2@x находится раньше, но остается a1@x - он раньше на входе
+	s := NewSpammer(usersByLetter{}, nil, nil)
+	out, errc := Run(ctx, s.SelectUsers(), sendAll("a1@x", "b1@x", "a2@x", "b2@x", "c1@x"))
+	res := make([]string, 0)
+	for u := range out {
+		res = append(res, u.Email)
+	}
+	require.NoError(t, <-errc)
+	assert.Equal(t, []string{"a1@x", "b1@x", "c1@x"}, res)
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stage - типизированное звено конвейера: читает In из in и пишет Out в out
+// закрывать out не нужно, это делает тот, кто запускает звено
+// при отмене ctx звено должно быстро вернуться, ошибку отдает как результат
+type Stage[In, Out any] func(ctx context.Context, in <-chan In, out chan<- Out) error
+
+// Step - звено с interface{}, в таком виде звенья собираются в RunPipelineContext
+type Step = Stage[interface{}, interface{}]
+
+// send пишет в канал, пока конвейер не остановлен
+func send[T any](ctx context.Context, out chan<- T, v T) bool {
+	select {
+	case out <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// recv читает из канала, пока он не закрыт и конвейер не остановлен
+func recv[T any](ctx context.Context, in <-chan T) (T, bool) {
+	var zero T
+	if ctx.Err() != nil {
+		return zero, false
+	}
+	select {
+	case v, ok := <-in:
+		return v, ok
+	case <-ctx.Done():
+		return zero, false
+	}
+}
+
+// await дожидается f или отмены ctx. f при этом не останавливается: после отмены
+// вызов брошен, его горутина живет, пока f не вернется, а результат выкидывается.
+// остановить сам вызов может только f, если она слушает ctx
+func await[T any](ctx context.Context, f func() (T, error)) (T, error) {
+	type result struct {
+		v   T
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, err := f()
+		done <- result{v, err}
+	}()
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// PipelineLimits - буферы и горутины конвейера
+type PipelineLimits struct {
+	Buffer        int            // буфер между звеньями в RunPipelineContext
+	StageBuffers  map[string]int // буфер на входе звена из Named, по имени звена
+	MaxGoroutines int            // сколько горутин на отдельные значения все звенья могут держать разом, 0 - без ограничения
+	OrderWindow   int            // сколько значений FlatMapOrdered без пула держит в ожидании своей очереди
+	Ordered       bool           // звенья задания отдают результаты в порядке входа
+}
+
+// DefaultPipelineLimits - ограничения для конвейеров, которым не задали свои через WithPipelineLimits,
+// в том числе для RunPipeline и звеньев из задания
+func DefaultPipelineLimits() PipelineLimits {
+	return PipelineLimits{MaxGoroutines: 10000, OrderWindow: 1024}
+}
+
+type limitsKey struct{}
+
+// WithPipelineLimits - контекст, в котором звенья и RunPipelineContext работают с ограничениями limits
+func WithPipelineLimits(ctx context.Context, limits PipelineLimits) context.Context {
+	return context.WithValue(ctx, limitsKey{}, limits)
+}
+
+func limitsFrom(ctx context.Context) PipelineLimits {
+	if limits, ok := ctx.Value(limitsKey{}).(PipelineLimits); ok {
+		return limits
+	}
+	return DefaultPipelineLimits()
+}
+
+// сколько горутин на отдельные значения сейчас запущено
+var itemGoroutines int64
+
+func acquireGoroutine(ctx context.Context) bool {
+	limit := int64(limitsFrom(ctx).MaxGoroutines)
+	if atomic.AddInt64(&itemGoroutines, 1) > limit && limit > 0 {
+		atomic.AddInt64(&itemGoroutines, -1)
+		return false
+	}
+	return true
+}
+
+func releaseGoroutine() {
+	atomic.AddInt64(&itemGoroutines, -1)
+}
+
+// source - откуда звено берет значения: значение, записи трассировки, из которых оно пришло, и есть ли оно
+type source[T any] func() (T, []uint64, bool)
+
+// chanSource читает in, trace ID берутся из входного потока звена
+func chanSource[T any](ctx context.Context, in <-chan T) source[T] {
+	link := linksFrom(ctx).in
+	return func() (T, []uint64, bool) {
+		v, id, ok := recvTraced(ctx, link, in)
+		return v, parentIDs(id), ok
+	}
+}
+
+// spawn берет значения из next и вызывает handle в workers горутин
+// workers <= 0 - своя горутина на каждое значение, пока не упремся в MaxGoroutines,
+// дальше значение обрабатывается прямо в читающей горутине, и чтение ждет
+func spawn[T any](ctx context.Context, workers int, next source[T], handle func(T, []uint64)) error {
+	wg := &sync.WaitGroup{}
+	if workers <= 0 {
+		for {
+			item, parents, ok := next()
+			if !ok {
+				break
+			}
+			if !acquireGoroutine(ctx) {
+				handle(item, parents)
+				continue
+			}
+			wg.Add(1)
+			go func(item T, parents []uint64) {
+				defer wg.Done()
+				defer releaseGoroutine()
+				handle(item, parents)
+			}(item, parents)
+		}
+		wg.Wait()
+		return ctx.Err()
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				item, parents, ok := next()
+				if !ok {
+					return
+				}
+				handle(item, parents)
+			}
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// itemHandler обрабатывает одно значение: политика ошибок, метрики и трассировка
+type itemHandler[In, Out any] struct {
+	ctx    context.Context
+	f      func(ctx context.Context, item In, emit func(Out)) error
+	run    *pipelineRun
+	m      *stageMetrics
+	traced bool
+}
+
+func newItemHandler[In, Out any](ctx context.Context, f func(ctx context.Context, item In, emit func(Out)) error) *itemHandler[In, Out] {
+	return &itemHandler[In, Out]{ctx: ctx, f: f, run: runFrom(ctx), m: stageFrom(ctx), traced: tracedFrom(ctx)}
+}
+
+// handle вызывает f для item, send получает каждый результат вместе с ID записи о нем
+func (h *itemHandler[In, Out]) handle(item In, parents []uint64, send func(v Out, id uint64) bool) {
+	emit := func(v Out) { send(v, 0) }
+	if h.m == nil {
+		h.run.handle(h.ctx, func() error { return h.f(h.ctx, item, emit) })
+		return
+	}
+	h.m.begin()
+	defer h.m.end()
+	start := time.Now()
+	span := Span{}
+	if h.traced {
+		span = Span{ID: tracing.newID(), Parents: parents, Stage: h.m.name, In: fmt.Sprint(item), Start: start}
+		emit = func(v Out) {
+			if send(v, span.ID) {
+				span.Out = append(span.Out, fmt.Sprint(v))
+			}
+		}
+	}
+	err := h.run.handle(h.ctx, func() error { return h.f(h.ctx, item, emit) })
+	span.Duration = time.Since(start)
+	h.m.observe(span.Duration)
+	if h.traced {
+		if err != nil {
+			span.Err = err.Error()
+		}
+		tracing.record(span)
+	}
+}
+
+// FlatMap - звено, которое вызывает f для каждого входа в workers горутин
+// f может отдать в emit сколько угодно значений. workers <= 0 - своя горутина на каждый вход,
+// пока их не больше MaxGoroutines из PipelineLimits
+// ошибки f обрабатываются по ErrorPolicy конвейера
+func FlatMap[In, Out any](workers int, f func(ctx context.Context, item In, emit func(Out)) error) Stage[In, Out] {
+	return func(ctx context.Context, in <-chan In, out chan<- Out) error {
+		return flatMap(ctx, workers, chanSource(ctx, in), out, f)
+	}
+}
+
+func flatMap[In, Out any](ctx context.Context, workers int, next source[In], out chan<- Out, f func(ctx context.Context, item In, emit func(Out)) error) error {
+	link := linksFrom(ctx).out
+	h := newItemHandler(ctx, f)
+	return spawn(ctx, workers, next, func(item In, parents []uint64) {
+		h.handle(item, parents, func(v Out, id uint64) bool { return sendTraced(ctx, link, out, v, id) })
+	})
+}
+
+// FlatMapOrdered - FlatMap, который отдает результаты в порядке входа
+// значения все так же обрабатываются параллельно, но ждут, пока отдадут результаты тех, кто пришел раньше.
+// ждать могут workers значений, без пула - OrderWindow из PipelineLimits
+func FlatMapOrdered[In, Out any](workers int, f func(ctx context.Context, item In, emit func(Out)) error) Stage[In, Out] {
+	return func(ctx context.Context, in <-chan In, out chan<- Out) error {
+		return flatMapOrdered(ctx, workers, chanSource(ctx, in), out, f)
+	}
+}
+
+func flatMapOrdered[In, Out any](ctx context.Context, workers int, next source[In], out chan<- Out, f func(ctx context.Context, item In, emit func(Out)) error) error {
+	type result struct {
+		v  Out
+		id uint64
+	}
+	type job struct {
+		item    In
+		parents []uint64
+		res     chan []result
+	}
+	window := workers
+	if window <= 0 {
+		window = limitsFrom(ctx).OrderWindow
+	}
+	// очередь на выдачу, по ней соблюдается порядок
+	pending := make(chan job, window)
+	jobs := make(chan job)
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+		for {
+			item, parents, ok := next()
+			if !ok {
+				return
+			}
+			j := job{item, parents, make(chan []result, 1)}
+			if !send(ctx, pending, j) || !send(ctx, jobs, j) {
+				return
+			}
+		}
+	}()
+
+	h := newItemHandler(ctx, f)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- spawn(ctx, workers, func() (job, []uint64, bool) {
+			j, ok := recv(ctx, jobs)
+			return j, j.parents, ok
+		}, func(j job, parents []uint64) {
+			res := make([]result, 0, 1)
+			h.handle(j.item, parents, func(v Out, id uint64) bool {
+				res = append(res, result{v, id})
+				return true
+			})
+			j.res <- res
+		})
+	}()
+
+	link := linksFrom(ctx).out
+	for j := range pending {
+		var res []result
+		select {
+		case res = <-j.res:
+		case <-ctx.Done():
+		}
+		for _, r := range res {
+			sendTraced(ctx, link, out, r.v, r.id)
+		}
+	}
+	return <-errc
+}
+
+// flatMapConfigured - FlatMap или FlatMapOrdered, смотря по Ordered из PipelineLimits
+func flatMapConfigured[In, Out any](workers int, f func(ctx context.Context, item In, emit func(Out)) error) Stage[In, Out] {
+	return func(ctx context.Context, in <-chan In, out chan<- Out) error {
+		return flatMapFrom(ctx, workers, chanSource(ctx, in), out, f)
+	}
+}
+
+func flatMapFrom[In, Out any](ctx context.Context, workers int, next source[In], out chan<- Out, f func(ctx context.Context, item In, emit func(Out)) error) error {
+	if limitsFrom(ctx).Ordered {
+		return flatMapOrdered(ctx, workers, next, out, f)
+	}
+	return flatMap(ctx, workers, next, out, f)
+}
+
+// Map - FlatMap, который на каждый вход отдает одно значение, если не было ошибки
+func Map[In, Out any](workers int, f func(ctx context.Context, item In) (Out, error)) Stage[In, Out] {
+	return FlatMap(workers, func(ctx context.Context, item In, emit func(Out)) error {
+		res, err := f(ctx, item)
+		if err != nil {
+			return err
+		}
+		emit(res)
+		return nil
+	})
+}
+
+// Then соединяет два звена, типы проверяются при компиляции
+func Then[A, B, C any](first Stage[A, B], second Stage[B, C]) Stage[A, C] {
+	return func(ctx context.Context, in <-chan A, out chan<- C) error {
+		mid := make(chan B)
+		links, link := linksFrom(ctx), newTraceLink()
+		firstCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		errc := make(chan error, 1)
+		go func() {
+			defer close(mid)
+			errc <- first(withLinks(firstCtx, links.in, link), in, mid)
+		}()
+		err := second(withLinks(ctx, link, links.out), mid, out)
+		// второе звено больше не читает mid: если оно вернулось раньше времени, первое встало бы на отправке
+		cancel()
+		firstErr := <-errc
+		if ctx.Err() == nil && errors.Is(firstErr, context.Canceled) {
+			firstErr = nil
+		}
+		return firstError(firstErr, err)
+	}
+}
+
+// Run запускает звено и возвращает канал с результатами, он закроется, когда звено отработает
+// в errc после этого придет то, что вернуло звено
+func Run[In, Out any](ctx context.Context, s Stage[In, Out], in <-chan In) (<-chan Out, <-chan error) {
+	out := make(chan Out)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(out)
+		errc <- s(ctx, in, out)
+	}()
+	return out, errc
+}
+
+// FanOut раздает значения из in по n каналам, каждое значение попадает в один из них
+func FanOut[T any](ctx context.Context, in <-chan T, n int) []<-chan T {
+	outs := make([]<-chan T, 0, n)
+	for i := 0; i < n; i++ {
+		out := make(chan T)
+		outs = append(outs, out)
+		go func(out chan<- T) {
+			defer close(out)
+			for {
+				v, ok := recv(ctx, in)
+				if !ok || !send(ctx, out, v) {
+					return
+				}
+			}
+		}(out)
+	}
+	return outs
+}
+
+// FanIn сливает несколько каналов в один
+func FanIn[T any](ctx context.Context, ins ...<-chan T) <-chan T {
+	out := make(chan T)
+	wg := &sync.WaitGroup{}
+	for _, in := range ins {
+		wg.Add(1)
+		go func(in <-chan T) {
+			defer wg.Done()
+			for {
+				v, ok := recv(ctx, in)
+				if !ok || !send(ctx, out, v) {
+					return
+				}
+			}
+		}(in)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// convert достает из interface{} значение нужного типа, в строку приводится что угодно
+func convert[T any](v interface{}) (T, error) {
+	if res, ok := v.(T); ok {
+		return res, nil
+	}
+	var res T
+	if s, ok := interface{}(&res).(*string); ok {
+		*s = fmt.Sprintf("%v", v)
+		return res, nil
+	}
+	return res, fmt.Errorf("pipeline: unexpected %T, want %T", v, res)
+}
+
+// Any превращает типизированное звено в Step
+// значение не того типа - ошибка, она обрабатывается по ErrorPolicy конвейера
+func (s Stage[In, Out]) Any() Step {
+	return func(ctx context.Context, in <-chan interface{}, out chan<- interface{}) error {
+		typed := make(chan In)
+		run := runFrom(ctx)
+		links, inner := linksFrom(ctx), traceLinks{newTraceLink(), newTraceLink()}
+		go func() {
+			defer close(typed)
+			for {
+				v, id, ok := recvTraced(ctx, links.in, in)
+				if !ok {
+					return
+				}
+				item, err := convert[In](v)
+				if err != nil {
+					run.fail(ctx, err)
+					continue
+				}
+				if !sendTraced(ctx, inner.in, typed, item, id) {
+					return
+				}
+			}
+		}()
+		res, errc := Run(withLinks(ctx, inner.in, inner.out), s, typed)
+		for v := range res {
+			sendTraced(ctx, links.out, out, interface{}(v), inner.out.pop())
+		}
+		return <-errc
+	}
+}
+
+// Cmd превращает типизированное звено в cmd для RunPipeline
+// у cmd нет контекста, поэтому такое звено нельзя отменить, а ошибки только пишутся в лог
+func (s Stage[In, Out]) Cmd() cmd {
+	return func(in, out chan interface{}) {
+		ctx := withLinks(context.Background(), cmdLink(in), cmdLink(out))
+		if err := s.Any()(ctx, in, out); err != nil {
+			log.Printf("pipeline: %v", err)
+		}
+	}
+}
+
+// Stage превращает cmd в Step. сам cmd прервать нельзя: при отмене ему закрывается вход,
+// а то, что он еще успеет выдать, выкидывается
+func (c cmd) Stage() Step {
+	return func(ctx context.Context, in <-chan interface{}, out chan<- interface{}) error {
+		cin := make(chan interface{})
+		cout := make(chan interface{})
+		go func() {
+			defer close(cin)
+			for {
+				v, ok := recv(ctx, in)
+				if !ok || !send(ctx, cin, v) {
+					return
+				}
+			}
+		}()
+		go func() {
+			defer close(cout)
+			c(cin, cout)
+		}()
+		drain := func() {
+			for range cout {
+			}
+		}
+		for {
+			v, ok := recv(ctx, cout)
+			if !ok {
+				if err := ctx.Err(); err != nil {
+					go drain()
+					return err
+				}
+				return nil
+			}
+			if !send(ctx, out, v) {
+				go drain()
+				return ctx.Err()
+			}
+		}
+	}
+}
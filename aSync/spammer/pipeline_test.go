@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sendAll[T any](items ...T) <-chan T {
+	in := make(chan T)
+	go func() {
+		defer close(in)
+		for _, v := range items {
+			in <- v
+		}
+	}()
+	return in
+}
+
+func collect[T any](in <-chan T) []T {
+	res := make([]T, 0)
+	for v := range in {
+		res = append(res, v)
+	}
+	return res
+}
+
+func TestStageThen(t *testing.T) {
+	ctx := context.Background()
+	double := Map(2, func(ctx context.Context, v int) (int, error) { return v * 2, nil })
+	odd := FlatMap(1, func(ctx context.Context, v int, emit func(int)) error {
+		if v%4 != 0 {
+			emit(v)
+		}
+		return nil
+	})
+	toString := FlatMap(0, func(ctx context.Context, v int, emit func(string)) error {
+		emit(strconv.Itoa(v))
+		emit(strconv.Itoa(-v))
+		return nil
+	})
+
+	out, errc := Run(ctx, Then(Then(double, odd), toString), sendAll(1, 2, 3, 4, 5))
+	res := collect(out)
+	sort.Strings(res)
+	assert.Equal(t, []string{"-10", "-2", "-6", "10", "2", "6"}, res)
+	assert.NoError(t, <-errc)
+}
+
+func TestStageWorkers(t *testing.T) {
+	var running, maxRunning int32
+	slow := Map(3, func(ctx context.Context, v int) (int, error) {
+		cur := atomic.AddInt32(&running, 1)
+		for {
+			prev := atomic.LoadInt32(&maxRunning)
+			if cur <= prev || atomic.CompareAndSwapInt32(&maxRunning, prev, cur) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return v, nil
+	})
+	out, _ := Run(context.Background(), slow, sendAll(1, 2, 3, 4, 5, 6, 7, 8, 9))
+	assert.Len(t, collect(out), 9)
+	assert.Equal(t, int32(3), maxRunning, "звено должно работать ровно в 3 горутины")
+}
+
+func TestFanOutFanIn(t *testing.T) {
+	ctx := context.Background()
+	outs := FanOut(ctx, sendAll(1, 2, 3, 4, 5, 6), 3)
+	assert.Len(t, outs, 3)
+	res := collect(FanIn(ctx, outs...))
+	sort.Ints(res)
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6}, res)
+}
+
+func TestStageCmd(t *testing.T) {
+	res := []string{}
+	stat = Stat{}
+	RunPipeline(
+		cmd(newCatStrings([]string{"batman@mail.ru", "bruce.wayne@mail.ru", "e.musk@mail.ru"}, 0)),
+		Then(Then(defaultSpammer.SelectUsers(), defaultSpammer.SelectMessages()), Map(0, func(ctx context.Context, id MsgID) (uint64, error) {
+			return uint64(id), nil
+		})).Cmd(),
+		cmd(newCollectStrings(&res)),
+	)
+	assert.Len(t, res, 14)
+	assert.Equal(t, uint32(1), stat.RunGetMessages)
+}
+
+func TestAnyWrongType(t *testing.T) {
+	res := []int{}
+	err := RunPipelineContext(context.Background(), ErrorPolicy{Mode: SkipAndRecord},
+		func(ctx context.Context, in <-chan interface{}, out chan<- interface{}) error {
+			for _, v := range []interface{}{1, "два", 3} {
+				send(ctx, out, v)
+			}
+			return nil
+		},
+		Map(1, func(ctx context.Context, v int) (int, error) { return v * 10, nil }).Any(),
+		func(ctx context.Context, in <-chan interface{}, out chan<- interface{}) error {
+			for v := range in {
+				res = append(res, v.(int))
+			}
+			return nil
+		},
+	)
+	assert.ErrorContains(t, err, "unexpected string, want int")
+	assert.Equal(t, []int{10, 30}, res)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrorMode - что делать конвейеру, если обработка значения завершилась ошибкой
+type ErrorMode int
+
+const (
+	// SkipAndRecord - значение пропускается, ошибка запоминается, конвейер работает дальше
+	SkipAndRecord ErrorMode = iota
+	// FailFast - конвейер останавливается на первой ошибке
+	FailFast
+	// Retry - значение обрабатывается заново, после последней попытки - как SkipAndRecord
+	Retry
+)
+
+type ErrorPolicy struct {
+	Mode    ErrorMode
+	Retries int           // сколько раз повторять в режиме Retry
+	Backoff time.Duration // пауза перед первым повтором, дальше она удваивается
+}
+
+// retry вызывает f, пока она не отработает без ошибки или не кончатся попытки
+// retryable решает, стоит ли повторять после такой ошибки, nil - повторять всегда
+func retry(ctx context.Context, retries int, backoff time.Duration, retryable func(error) bool, f func() error) error {
+	err := f()
+	for i := 0; i < retries && err != nil; i++ {
+		if ctx.Err() != nil || (retryable != nil && !retryable(err)) {
+			return err
+		}
+		timer := time.NewTimer(backoff << i)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		err = f()
+	}
+	return err
+}
+
+// firstError - первая ошибка, которая не просто отмена контекста
+func firstError(errs ...error) error {
+	var res error
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		if res == nil {
+			res = err
+		}
+	}
+	return res
+}
+
+type runKey struct{}
+
+// pipelineRun - общее для всех звеньев одного запуска: политика ошибок и сами ошибки
+type pipelineRun struct {
+	policy  ErrorPolicy
+	cancel  context.CancelFunc
+	logOnly bool
+	mu      sync.Mutex
+	errs    []error
+}
+
+func runFrom(ctx context.Context) *pipelineRun {
+	if run, ok := ctx.Value(runKey{}).(*pipelineRun); ok {
+		return run
+	}
+	// звено запущено само по себе, ошибки собирать некому
+	return &pipelineRun{policy: ErrorPolicy{Mode: SkipAndRecord}, logOnly: true}
+}
+
+// handle выполняет обработку одного значения с учетом политики
+// возвращает ошибку, которая осталась после всех попыток
+func (r *pipelineRun) handle(ctx context.Context, f func() error) error {
+	var err error
+	if r.policy.Mode == Retry {
+		err = retry(ctx, r.policy.Retries, r.policy.Backoff, nil, f)
+	} else {
+		err = f()
+	}
+	r.fail(ctx, err)
+	return err
+}
+
+func (r *pipelineRun) fail(ctx context.Context, err error) {
+	if err == nil || (ctx.Err() != nil && errors.Is(err, ctx.Err())) {
+		return
+	}
+	stageFrom(ctx).failed()
+	if r.logOnly {
+		log.Printf("pipeline: %v", err)
+		return
+	}
+	r.mu.Lock()
+	r.errs = append(r.errs, err)
+	r.mu.Unlock()
+	if r.policy.Mode == FailFast && r.cancel != nil {
+		r.cancel()
+	}
+}
+
+// err - первая ошибка для FailFast, иначе все ошибки разом
+func (r *pipelineRun) err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.errs) == 0 {
+		return nil
+	}
+	if r.policy.Mode == FailFast {
+		return r.errs[0]
+	}
+	return errors.Join(r.errs...)
+}
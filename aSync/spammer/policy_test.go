@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// numbers - звено, которое выдает 1, 2, 3 ... пока его не остановят
+func numbers(limit int) Step {
+	return func(ctx context.Context, in <-chan interface{}, out chan<- interface{}) error {
+		for i := 1; limit == 0 || i <= limit; i++ {
+			if !send(ctx, out, interface{}(i)) {
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+}
+
+func TestPipelineCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var got int32
+	start := time.Now()
+	err := RunPipelineContext(ctx, ErrorPolicy{Mode: FailFast},
+		numbers(0),
+		Map(4, func(ctx context.Context, v int) (int, error) {
+			time.Sleep(time.Millisecond)
+			return v, nil
+		}).Any(),
+		cmd(func(in, out chan interface{}) {
+			for range in {
+				atomic.AddInt32(&got, 1)
+			}
+		}).Stage(),
+	)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 200*time.Millisecond, "конвейер должен остановиться сразу после отмены")
+	assert.NotZero(t, atomic.LoadInt32(&got))
+}
+
+func TestPipelineFailFast(t *testing.T) {
+	errBad := errors.New("bad number")
+	var handled int32
+	err := RunPipelineContext(context.Background(), ErrorPolicy{Mode: FailFast},
+		numbers(0),
+		Map(1, func(ctx context.Context, v int) (int, error) {
+			atomic.AddInt32(&handled, 1)
+			if v == 10 {
+				return 0, errBad
+			}
+			return v, nil
+		}).Any(),
+	)
+	assert.ErrorIs(t, err, errBad)
+	assert.Equal(t, int32(10), atomic.LoadInt32(&handled))
+}
+
+func TestPipelineSkipAndRecord(t *testing.T) {
+	res := make([]interface{}, 0)
+	err := RunPipelineContext(context.Background(), ErrorPolicy{Mode: SkipAndRecord},
+		numbers(6),
+		Map(1, func(ctx context.Context, v int) (int, error) {
+			if v%3 == 0 {
+				return 0, errors.New("skip " + strings.Repeat("!", v/3))
+			}
+			return v, nil
+		}).Any(),
+		func(ctx context.Context, in <-chan interface{}, out chan<- interface{}) error {
+			for v := range in {
+				res = append(res, v)
+			}
+			return nil
+		},
+	)
+	assert.Equal(t, []interface{}{1, 2, 4, 5}, res)
+	assert.EqualError(t, err, "skip !\nskip !!")
+}
+
+func TestPipelineRetry(t *testing.T) {
+	var calls int32
+	res := make([]interface{}, 0)
+	err := RunPipelineContext(context.Background(), ErrorPolicy{Mode: Retry, Retries: 3, Backoff: time.Millisecond},
+		numbers(1),
+		Map(1, func(ctx context.Context, v int) (int, error) {
+			if atomic.AddInt32(&calls, 1) < 3 {
+				return 0, errors.New("try again")
+			}
+			return v, nil
+		}).Any(),
+		func(ctx context.Context, in <-chan interface{}, out chan<- interface{}) error {
+			for v := range in {
+				res = append(res, v)
+			}
+			return nil
+		},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{1}, res)
+	assert.Equal(t, int32(3), calls)
+}
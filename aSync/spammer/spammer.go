@@ -1,133 +1,317 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"sort"
+	"reflect"
+	"runtime"
+	"strings"
 	"sync"
 )
 
+// RunPipeline соединяет cmd каналами и запускает как есть, без контекста
+// звенья из Stage.Cmd сами пишут свои ошибки в лог, вернуть их здесь нельзя
+// каждый cmd виден в метриках как RunPipeline/<имя функции>
 func RunPipeline(cmds ...cmd) {
 	in := make(chan interface{})
+	close(in)
+	traced := tracing.enabled()
 	wg := &sync.WaitGroup{}
-
 	for _, com := range cmds {
-		out := make(chan interface{})
+		out := make(chan interface{}, DefaultPipelineLimits().Buffer)
+		if traced {
+			registerCmdLink(out, &traceLink{})
+			defer unregisterCmdLink(out)
+		}
 		wg.Add(1)
-		go func(in chan interface{}, out chan interface{}, com cmd, wg *sync.WaitGroup) {
-			com(in, out)
-			close(out)
-			wg.Done()
-		}(in, out, com, wg)
+		go func(in, out chan interface{}, com cmd) {
+			defer wg.Done()
+			defer close(out)
+			measureCmd("RunPipeline/"+cmdName(com), com, in, out)
+		}(in, out, com)
 		in = out
-
 	}
+	// выход последнего звена никто не читает
+	go func(in <-chan interface{}) {
+		for range in {
+		}
+	}(in)
 	wg.Wait()
 }
 
-func SelectUsers(in, out chan interface{}) {
-	list := make(map[uint64]string)
-	str := ""
-	user := User{}
-	mu := &sync.Mutex{}
+// RunPipelineContext запускает звенья друг за другом и ждет, пока все отработают
+// при отмене ctx звенья останавливаются. ошибки обрабатываются по policy:
+// для FailFast возвращается первая ошибка, для остальных режимов - все сразу
+func RunPipelineContext(ctx context.Context, policy ErrorPolicy, steps ...Step) error {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	run := &pipelineRun{policy: policy, cancel: cancel}
+	ctx = context.WithValue(ctx, runKey{}, run)
+
+	in := make(chan interface{})
+	close(in)
+	var link *traceLink
 	wg := &sync.WaitGroup{}
-	for i := range in {
-		str = fmt.Sprintf("%v", i)
+	for _, step := range steps {
+		out, next := make(chan interface{}, limitsFrom(ctx).Buffer), newTraceLink()
 		wg.Add(1)
-		go func(str string, wg *sync.WaitGroup, list *map[uint64]string, user User) {
-			user = GetUser(str)
-			mu.Lock()
-			_, ok := (*list)[user.ID]
-			if !ok {
-				(*list)[user.ID] = str
-				out <- user
-			}
-			mu.Unlock()
-			wg.Done()
-		}(str, wg, &list, user)
+		go func(in <-chan interface{}, out chan interface{}, step Step, ctx context.Context) {
+			defer wg.Done()
+			defer close(out)
+			run.fail(ctx, step(ctx, in, out))
+		}(in, out, step, withLinks(ctx, link, next))
+		in, link = out, next
 	}
+	// выход последнего звена никто не читает
+	go func(in <-chan interface{}) {
+		for range in {
+		}
+	}(in)
 	wg.Wait()
+
+	if err := run.err(); err != nil {
+		return err
+	}
+	return parent.Err()
+}
+
+// cmdName - имя функции cmd без пакета
+func cmdName(c cmd) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(c).Pointer())
+	if fn == nil {
+		return "cmd"
+	}
+	return strings.TrimPrefix(fn.Name(), "main.")
+}
+
+// CheckEmails прогоняет имейлы через всю цепочку, например в рамках запроса с дедлайном
+func CheckEmails(ctx context.Context, policy ErrorPolicy, emails []string) ([]string, error) {
+	return defaultSpammer.CheckEmails(ctx, policy, emails)
+}
+
+func (s *Spammer) CheckEmails(ctx context.Context, policy ErrorPolicy, emails []string) ([]string, error) {
+	res := make([]string, 0)
+	err := RunPipelineContext(ctx, policy,
+		func(ctx context.Context, in <-chan interface{}, out chan<- interface{}) error {
+			for _, email := range emails {
+				if !send(ctx, out, interface{}(email)) {
+					return ctx.Err()
+				}
+			}
+			return nil
+		},
+		s.Results().Any(),
+		func(ctx context.Context, in <-chan interface{}, out chan<- interface{}) error {
+			var err error
+			for v := range in {
+				m := v.(MsgData)
+				res = append(res, formatMsg(m))
+				if s.checkpoint != nil && err == nil {
+					err = s.checkpoint.MarkEmitted(m.ID)
+				}
+			}
+			return err
+		},
+	)
+	return res, err
+}
+
+func SelectUsers(in, out chan interface{}) {
+	defaultSpammer.SelectUsers().Cmd()(in, out)
 }
 
 func SelectMessages(in, out chan interface{}) {
+	defaultSpammer.SelectMessages().Cmd()(in, out)
+}
+
+func CheckSpam(in, out chan interface{}) {
+	defaultSpammer.CheckSpam().Cmd()(in, out)
+}
+
+func CombineResults(in, out chan interface{}) {
+	defaultSpammer.CombineResults().Cmd()(in, out)
+}
+
+// Pipeline - вся цепочка от имейлов до строк с результатом
+func (s *Spammer) Pipeline() Stage[string, string] {
+	return Then(s.Results(), Stage[MsgData, string](formatMsgs))
+}
+
+// Results - вся цепочка от имейлов до отсортированных результатов
+func (s *Spammer) Results() Stage[string, MsgData] {
+	return Then(Then(Then(s.SelectUsers(), s.SelectMessages()), s.CheckSpam()), s.SortResults())
+}
+
+func (s *Spammer) SelectUsers() Stage[string, User] {
+	return Named("SelectUsers", func(ctx context.Context, in <-chan string, out chan<- User) error {
+		users := newCachedUsers(s.users, s.cache)
+		lookup := flatMapConfigured(0, func(ctx context.Context, email string, emit func(User)) error {
+			key := email
+			if s.aliases != nil {
+				key = s.aliases.Resolve(email)
+			}
+			user, err := s.getUser(ctx, users, key)
+			if err != nil {
+				return err
+			}
+			if s.report != nil {
+				s.report.add(user, email)
+			}
+			emit(user)
+			return nil
+		})
+		return Then(lookup, Stage[User, User](uniqueUsers))(ctx, in, out)
+	})
+}
+
+// uniqueUsers пропускает каждого юзера один раз. с Ordered юзеры приходят в порядке имейлов,
+// поэтому остается юзер по первому имейлу со входа, а не тот, кого нашли раньше.
+// trace ID юзера уходит вместе с ним, так что SelectMessages видит, из какого имейла он пришел
+func uniqueUsers(ctx context.Context, in <-chan User, out chan<- User) error {
+	links := linksFrom(ctx)
+	seen := make(map[uint64]bool)
+	for {
+		user, id, ok := recvTraced(ctx, links.in, in)
+		if !ok {
+			return ctx.Err()
+		}
+		if seen[user.ID] {
+			continue
+		}
+		seen[user.ID] = true
+		if !sendTraced(ctx, links.out, out, user, id) {
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *Spammer) SelectMessages() Stage[User, MsgID] {
 	// 	in - User
 	// 	out - MsgID
-	flag := false
-	users := make([]User, 0)
-	wg := &sync.WaitGroup{}
-	for i := range in {
-		if flag {
-			flag = false
-			wg.Add(1)
-			users = append(users, i.(User))
-			go func(users []User, wg *sync.WaitGroup) {
-				if res, err := GetMessages(users...); err != nil {
-					fmt.Printf("Error: %v", err)
-				} else {
-					for _, j := range res {
-						out <- j
+	return Named("SelectMessages", func(ctx context.Context, in <-chan User, out chan<- MsgID) error {
+		// пачки из контрольных точек, чьи письма уже отданы в этом запуске
+		replayed := &sync.Map{}
+		size := GetMessagesMaxUsersBatch
+		if s.batchSize > 0 {
+			size = s.batchSize
+		}
+		return Batch(size, s.batchWait, func(ctx context.Context, users []User, emit func(MsgID)) error {
+			if s.checkpoint != nil {
+				pending := make([]User, 0, len(users))
+				for _, user := range users {
+					batch := s.checkpoint.batch(user)
+					if batch == 0 {
+						pending = append(pending, user)
+						continue
+					}
+					if _, done := replayed.LoadOrStore(batch, true); !done {
+						for _, id := range s.checkpoint.batchMessages(batch) {
+							emit(id)
+						}
 					}
 				}
-				wg.Done()
-			}(users, wg)
-			users = make([]User, 0)
-		} else {
-			users = append(users, i.(User))
-			flag = true
-		}
-	}
-	if flag {
-		if res, err := GetMessages(users...); err != nil {
-			fmt.Printf("Error: %v", err)
-		} else {
-			for _, j := range res {
-				out <- j
+				if len(pending) == 0 {
+					return nil
+				}
+				users = pending
 			}
-		}
-	}
-	wg.Wait()
+			res, err := s.messages.GetMessages(ctx, users...)
+			if err != nil {
+				return fmt.Errorf("get messages: %w", err)
+			}
+			if s.checkpoint != nil {
+				if err := s.checkpoint.saveBatch(users, res); err != nil {
+					return err
+				}
+			}
+			for _, id := range res {
+				emit(id)
+			}
+			return nil
+		})(ctx, in, out)
+	})
 }
 
-func CheckSpam(in, out chan interface{}) {
+func (s *Spammer) CheckSpam() Stage[MsgID, MsgData] {
 	// in - MsgID
 	// out - MsgData
-	res := MsgData{}
-	wg := &sync.WaitGroup{}
-	for i := 0; i < 5; i++ {
-		wg.Add(1)
-		go func(in chan interface{}, res MsgData, wg *sync.WaitGroup) {
-			for inp := range in {
-
-				spam, err := HasSpam(inp.(MsgID))
-				if err == nil {
-					res.ID = inp.(MsgID)
-					res.HasSpam = spam
-					out <- res
-				} else {
-					fmt.Printf("Error: %v", err)
+	return Named("CheckSpam", func(ctx context.Context, in <-chan MsgID, out chan<- MsgData) error {
+		limits := CheckSpamLimits
+		if s.spamLimits != nil {
+			limits = *s.spamLimits
+		}
+		limits = limits.withDefaults(HasSpamMaxAsyncRequests)
+		limiter := NewLimiter(limits.MaxInFlight, limits.PerSecond)
+		return flatMapConfigured(limits.MaxInFlight, func(ctx context.Context, id MsgID, emit func(MsgData)) error {
+			res := MsgData{ID: id}
+			if s.checkpoint != nil {
+				if hasSpam, ok := s.checkpoint.hasSpam(id); ok {
+					res.HasSpam = hasSpam
+					emit(res)
+					return nil
 				}
-
 			}
-			wg.Done()
-		}(in, res, wg)
-	}
-	wg.Wait()
+			err := limiter.Call(ctx, limits, func() (err error) {
+				res.HasSpam, err = s.spam.HasSpam(ctx, id)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("has spam %d: %w", id, err)
+			}
+			if s.checkpoint != nil {
+				if err := s.checkpoint.saveSpam(res); err != nil {
+					return err
+				}
+			}
+			emit(res)
+			return nil
+		})(ctx, in, out)
+	})
 }
 
-func CombineResults(in, out chan interface{}) {
+func (s *Spammer) CombineResults() Stage[MsgData, string] {
 	// in - MsgData
 	// out - string
-	mas := make([]MsgData, 0)
-	for i := range in {
-		mas = append(mas, i.(MsgData))
-	}
-	sort.Slice(mas, func(k, l int) bool {
-		if mas[k].HasSpam == mas[l].HasSpam {
-			return mas[k].ID < mas[l].ID
+	return Then(s.SortResults(), Stage[MsgData, string](formatMsgs))
+}
+
+// SortResults - CombineResults без перевода в строки
+func (s *Spammer) SortResults() Stage[MsgData, MsgData] {
+	return Named("CombineResults", func(ctx context.Context, in <-chan MsgData, out chan<- MsgData) error {
+		var combine Stage[MsgData, MsgData]
+		switch cfg := s.combine; cfg.Mode {
+		case CombineStream:
+			combine = combineStream(cfg)
+		case CombineTopK:
+			combine = combineTopK(cfg.TopK)
+		case CombineExternal:
+			combine = combineExternal(cfg)
+		default:
+			combine = combineAll
+		}
+		if s.checkpoint != nil {
+			combine = Then(Stage[MsgData, MsgData](s.checkpoint.pending), combine)
 		}
-		return mas[k].HasSpam
+		return combine(ctx, in, out)
 	})
-	for _, j := range mas {
-		out <- fmt.Sprintf("%v %v", j.HasSpam, j.ID)
-	}
+}
 
+// getUser берет юзера из контрольных точек, если он там есть, иначе из кеша или сервиса
+func (s *Spammer) getUser(ctx context.Context, users *cachedUsers, email string) (User, error) {
+	if s.checkpoint != nil {
+		if user, ok := s.checkpoint.user(email); ok {
+			return user, nil
+		}
+	}
+	user, err := users.get(ctx, email)
+	if err != nil {
+		return User{}, err
+	}
+	if s.checkpoint != nil {
+		if err := s.checkpoint.saveUser(email, user); err != nil {
+			return User{}, err
+		}
+	}
+	return user, nil
 }
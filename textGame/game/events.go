@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// eventConfig - событие мира: срабатывает каждые every ходов или раз в period
+// игроки в комнате room видят message в ответе на следующую команду
+type eventConfig struct {
+	Every   int            `json:"every"`
+	Period  string         `json:"period"`
+	Room    string         `json:"room"`
+	Message string         `json:"message"`
+	Effects []effectConfig `json:"effects"`
+}
+
+type scheduledEvent struct {
+	eventConfig
+	period time.Duration
+	last   time.Time
+}
+
+// npcConfig - персонаж, который раз в every ходов переходит в следующую комнату маршрута
+type npcConfig struct {
+	Name  string    `json:"name"`
+	Forms nounForms `json:"forms"`
+	Route []string  `json:"route"`
+	Every int       `json:"every"`
+	Lines []string  `json:"lines"`
+}
+
+// NPC - персонаж мира, с ним можно поговорить командой говорить
+type NPC struct {
+	Name  string
+	Forms nounForms
+	Place *Room
+	Route []*Room
+	Every int
+	Lines []string
+	step  int
+	line  int
+}
+
+func (npc NPC) getName() string {
+	return npc.Name
+}
+
+func (npc NPC) getForms() nounForms {
+	return npc.Forms
+}
+
+// called - назван ли персонаж: сосед или с соседом
+func (npc *NPC) called(name string) bool {
+	return name == npc.Name || name == nameCase(npc, caseIns)
+}
+
+func (w *World) buildEvents(cfg *worldConfig, doors map[string]*Door) error {
+	for _, ec := range cfg.Events {
+		ev := &scheduledEvent{eventConfig: ec, last: w.started}
+		if ec.Period != "" {
+			period, err := time.ParseDuration(ec.Period)
+			if err != nil || period <= 0 {
+				return fmt.Errorf("event %q: bad period %q", ec.Message, ec.Period)
+			}
+			ev.period = period
+		}
+		if ev.Every <= 0 && ev.period == 0 {
+			return fmt.Errorf("event %q: every or period is required", ec.Message)
+		}
+		if ec.Room != "" {
+			if _, ok := w.Rooms[ec.Room]; !ok {
+				return fmt.Errorf("event %q: unknown room %q", ec.Message, ec.Room)
+			}
+		}
+		for _, eff := range ec.Effects {
+			if err := checkEffect(eff, "", w.Rooms, doors); err != nil {
+				return fmt.Errorf("event %q: %w", ec.Message, err)
+			}
+		}
+		w.events = append(w.events, ev)
+	}
+
+	for _, nc := range cfg.NPCs {
+		if len(nc.Route) == 0 {
+			return fmt.Errorf("npc %q: empty route", nc.Name)
+		}
+		npc := &NPC{Name: nc.Name, Forms: nc.Forms, Every: nc.Every, Lines: nc.Lines}
+		for _, name := range nc.Route {
+			room, ok := w.Rooms[name]
+			if !ok {
+				return fmt.Errorf("npc %q: unknown room %q", nc.Name, name)
+			}
+			npc.Route = append(npc.Route, room)
+		}
+		npc.Place = npc.Route[0]
+		w.npcs = append(w.npcs, npc)
+	}
+	return nil
+}
+
+func (p *Player) takeNews() []string {
+	news := p.news
+	p.news = nil
+	return news
+}
+
+// notify показывает сообщение всем игрокам в комнате
+func (w *World) notify(room *Room, msg string) {
+	if msg == "" {
+		return
+	}
+	for _, name := range sortedKeys(w.Players) {
+		if p := w.Players[name]; room == nil || p.Place == room {
+			p.news = append(p.news, msg)
+		}
+	}
+}
+
+func (w *World) due(every int) bool {
+	return every > 0 && w.turn > 0 && w.turn%every == 0
+}
+
+// maxMissedEvents - сколько раз подряд событие может сработать за один ход,
+// если с прошлой команды прошло много периодов
+const maxMissedEvents = 100
+
+// tick выполняет все события, которым пора случиться перед очередным ходом
+// событие по времени срабатывает столько раз, сколько его периодов прошло
+func (w *World) tick(now time.Time) {
+	for _, ev := range w.events {
+		times := 0
+		if ev.period > 0 && now.After(ev.last) {
+			missed := now.Sub(ev.last) / ev.period
+			ev.last = ev.last.Add(missed * ev.period)
+			times = int(min(missed, maxMissedEvents))
+		}
+		if times == 0 && w.due(ev.Every) {
+			times = 1
+		}
+		var room *Room
+		if ev.Room != "" {
+			room = w.Rooms[ev.Room]
+		}
+		for ; times > 0; times-- {
+			for _, eff := range ev.Effects {
+				w.applyEffect(nil, room, "", eff)
+			}
+			w.notify(room, ev.Message)
+		}
+	}
+	for _, npc := range w.npcs {
+		if len(npc.Route) > 1 && w.due(npc.Every) {
+			w.moveNPC(npc)
+		}
+	}
+}
+
+func (w *World) moveNPC(npc *NPC) {
+	from := npc.Place
+	npc.step = (npc.step + 1) % len(npc.Route)
+	npc.Place = npc.Route[npc.step]
+	if npc.Place == from {
+		return
+	}
+	w.notify(from, npc.Name+" ушел "+npc.Place.preposition()+" "+nameCase(npc.Place, caseAcc))
+	w.notify(npc.Place, "пришел "+npc.Name)
+}
+
+func (w *World) npcsIn(room *Room) []string {
+	names := make([]string, 0)
+	for _, npc := range w.npcs {
+		if npc.Place == room {
+			names = append(names, npc.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (w *World) talk(p *Player, name string) string {
+	for _, npc := range w.npcs {
+		if !npc.called(name) {
+			continue
+		}
+		// в ответе имя, а не "с соседом"
+		name = npc.Name
+		if npc.Place != p.Place {
+			continue
+		}
+		if len(npc.Lines) == 0 {
+			return npc.Name + " молчит"
+		}
+		line := npc.Lines[npc.line%len(npc.Lines)]
+		npc.line++
+		return npc.Name + ": " + line
+	}
+	return "здесь нет - " + name
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventsAndNPC(t *testing.T) {
+	cfg, err := loadWorld("levels/roommate.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWorld(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	w.clock = func() time.Time { return now }
+
+	checkSteps(t, w, defaultPlayer, []gameStep{
+		{"осмотреться", "ты находишься на кухне, на столе: чай, надо собрать рюкзак и идти в универ. можно пройти - коридор"},
+		{"идти коридор", "ничего интересного. можно пройти - кухня, комната, улица"},
+		{"говорить сосед", "здесь нет - сосед. сосед ушел на кухню"},
+		{"идти кухня", "кухня, ничего интересного. можно пройти - коридор"},
+		{"идти коридор", "ничего интересного. можно пройти - кухня, комната, улица. сосед ушел в коридор"},
+		{"говорить с сосед", "сосед: привет"},
+		{"поговорить сосед", "здесь нет - сосед. сосед ушел на кухню"},
+		{"осмотреться", "пустая комната. можно пройти - кухня, комната, улица. сквозняк захлопнул дверь"},
+	})
+	now = now.Add(time.Minute)
+	checkSteps(t, w, defaultPlayer, []gameStep{
+		{"идти кухня", "кухня, ничего интересного. можно пройти - коридор. пришел сосед"},
+		// чай уже стоит на столе, второй не появляется
+		{"осмотреться", "ты находишься на кухне, на столе: чай, надо собрать рюкзак и идти в универ. можно пройти - коридор"},
+	})
+
+	// за три минуты чайник вскипел три раза, а не один
+	now = now.Add(3*time.Minute + time.Second)
+	if answer := w.handleCommand(defaultPlayer, "говорить с соседом"); answer != "сосед: не забудь конспекты. чайник вскипел. чайник вскипел. чайник вскипел. пришел сосед" {
+		t.Error(answer)
+	}
+	if problems := w.validate(); len(problems) != 0 {
+		t.Error("events broke the world:", problems)
+	}
+
+	// выпитый чай появляется снова
+	w.Rooms["кухня"].takeThing("чай")
+	now = now.Add(time.Minute)
+	checkSteps(t, w, defaultPlayer, []gameStep{
+		{"осмотреться", "ты находишься на кухне, на столе: чай, надо собрать рюкзак и идти в универ. можно пройти - коридор. кроме тебя здесь: сосед. чайник вскипел"},
+	})
+
+	w.saveDir = t.TempDir()
+	if err := w.save("npc"); err != nil {
+		t.Fatal(err)
+	}
+	npc := w.npcs[0]
+	place := npc.Place.Name
+	w.moveNPC(npc)
+	if err := w.load("npc"); err != nil {
+		t.Fatal(err)
+	}
+	if npc.Place != w.Rooms[place] || npc.Route[0] != w.Rooms["коридор"] {
+		t.Error("npc state is not restored")
+	}
+
+	// испорченное состояние не трогает мир
+	state := w.snapshot()
+	state.NPCs[0].Place = "чердак"
+	rooms := w.Rooms
+	if err := w.restore(state); err == nil {
+		t.Error("expected error for unknown npc room")
+	}
+	if w.Rooms["коридор"] != rooms["коридор"] || npc.Place != w.Rooms[place] || npc.Route[0] != w.Rooms["коридор"] {
+		t.Error("failed restore changed the world")
+	}
+}
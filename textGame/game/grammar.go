@@ -0,0 +1,189 @@
+package main
+
+import (
+	"strings"
+)
+
+type nounCase int
+
+const (
+	caseNom  nounCase = iota // именительный: что? стол
+	caseAcc                  // винительный: на что? на стол
+	casePrep                 // предложный: на чем? на столе
+	caseIns                  // творительный: с кем? с соседом
+)
+
+// nounForms - падежные формы названия, пустые формы строятся по правилам inflect
+type nounForms struct {
+	Nom  string `json:"nom"`
+	Acc  string `json:"acc"`
+	Prep string `json:"prep"`
+	Ins  string `json:"ins"`
+}
+
+func (f nounForms) get(name string, c nounCase) string {
+	switch {
+	case c == caseNom && f.Nom != "":
+		return f.Nom
+	case c == caseAcc && f.Acc != "":
+		return f.Acc
+	case c == casePrep && f.Prep != "":
+		return f.Prep
+	case c == caseIns && f.Ins != "":
+		return f.Ins
+	}
+	return inflect(name, c)
+}
+
+func nameCase(n Nameable, c nounCase) string {
+	return n.getForms().get(n.getName(), c)
+}
+
+var adjEndings = map[nounCase]map[string]string{
+	caseAcc: {
+		"ая": "ую",
+		"яя": "юю",
+	},
+	casePrep: {
+		"ый": "ом",
+		"ой": "ом",
+		"ий": "ем",
+		"ая": "ой",
+		"яя": "ей",
+		"ое": "ом",
+		"ее": "ем",
+		"ые": "ых",
+		"ие": "их",
+	},
+	caseIns: {
+		"ый": "ым",
+		"ой": "ым",
+		"ий": "им",
+		"ая": "ой",
+		"яя": "ей",
+		"ое": "ым",
+		"ее": "им",
+		"ые": "ыми",
+		"ие": "ими",
+	},
+}
+
+func isAdjective(word string) bool {
+	for _, ending := range []string{"ый", "ой", "ий", "ая", "яя", "ое", "ее", "ые", "ие"} {
+		if strings.HasSuffix(word, ending) && len([]rune(word)) > 3 {
+			return true
+		}
+	}
+	return false
+}
+
+func replaceSuffix(word, suffix, repl string) string {
+	return strings.TrimSuffix(word, suffix) + repl
+}
+
+func inflectAdjective(word string, c nounCase) string {
+	for ending, repl := range adjEndings[c] {
+		if !strings.HasSuffix(word, ending) {
+			continue
+		}
+		// маленький - маленьком, а не маленькем
+		stem := lastRune(strings.TrimSuffix(word, ending))
+		if ending == "ий" && c == casePrep && strings.ContainsAny(stem, "гкхжчшщ") {
+			repl = "ом"
+		}
+		// младшая - с младшей
+		if ending == "ая" && c != caseAcc && strings.ContainsAny(stem, "жчшщ") {
+			repl = "ей"
+		}
+		return replaceSuffix(word, ending, repl)
+	}
+	return word
+}
+
+func lastRune(word string) string {
+	r := []rune(word)
+	if len(r) == 0 {
+		return ""
+	}
+	return string(r[len(r)-1])
+}
+
+// inflectNoun склоняет существительное по самым частым правилам:
+// стол - на столе, полка - на полку/на полке, ключи - в ключах, кровать - на кровати, сосед - с соседом
+func inflectNoun(word string, c nounCase) string {
+	last := lastRune(word)
+	switch c {
+	case caseAcc:
+		switch last {
+		case "а":
+			return replaceSuffix(word, "а", "у")
+		case "я":
+			return replaceSuffix(word, "я", "ю")
+		}
+	case casePrep:
+		switch {
+		case strings.HasSuffix(word, "ие"), strings.HasSuffix(word, "ия"):
+			return replaceSuffix(word, last, "и")
+		case last == "ь":
+			return replaceSuffix(word, "ь", "и")
+		case last == "й", last == "а", last == "я", last == "о":
+			return replaceSuffix(word, last, "е")
+		case last == "е":
+			return word
+		case last == "ы":
+			return replaceSuffix(word, "ы", "ах")
+		case last == "и":
+			stem := strings.TrimSuffix(word, "и")
+			if strings.ContainsAny(lastRune(stem), "гкхжчшщц") {
+				return stem + "ах"
+			}
+			return stem + "ях"
+		case strings.ContainsAny(last, "бвгджзклмнпрстфхцчшщ"):
+			return word + "е"
+		}
+	case caseIns:
+		stem := strings.TrimSuffix(word, last)
+		hushing := strings.ContainsAny(lastRune(stem), "жчшщц")
+		switch {
+		case last == "а" && hushing:
+			return stem + "ей"
+		case last == "а":
+			return stem + "ой"
+		case last == "я":
+			return stem + "ей"
+		case last == "й", last == "ь":
+			return stem + "ем"
+		case last == "о", last == "е":
+			return word + "м"
+		case last == "ы":
+			return stem + "ами"
+		case last == "и" && strings.ContainsAny(lastRune(stem), "гкхжчшщц"):
+			return stem + "ами"
+		case last == "и":
+			return stem + "ями"
+		case strings.ContainsAny(last, "жчшщц"):
+			return word + "ем"
+		case strings.ContainsAny(last, "бвгдзклмнпрстфх"):
+			return word + "ом"
+		}
+	}
+	return word
+}
+
+// inflect склоняет название: прилагательные в начале и первое существительное,
+// остальное не трогаем - "ключи от двери" - "ключах от двери"
+func inflect(name string, c nounCase) string {
+	if c == caseNom {
+		return name
+	}
+	words := strings.Split(name, " ")
+	for i, word := range words {
+		if isAdjective(word) && i != len(words)-1 {
+			words[i] = inflectAdjective(word, c)
+			continue
+		}
+		words[i] = inflectNoun(word, c)
+		break
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestInflect(t *testing.T) {
+	cases := []struct {
+		name string
+		acc  string
+		prep string
+	}{
+		{"стол", "стол", "столе"},
+		{"стул", "стул", "стуле"},
+		{"полка", "полку", "полке"},
+		{"тумбочка", "тумбочку", "тумбочке"},
+		{"кровать", "кровать", "кровати"},
+		{"окно", "окно", "окне"},
+		{"здание", "здание", "здании"},
+		{"чай", "чай", "чае"},
+		{"ключи", "ключи", "ключах"},
+		{"конспекты", "конспекты", "конспектах"},
+		{"двери", "двери", "дверях"},
+		{"красный ключ", "красный ключ", "красном ключе"},
+		{"маленький стол", "маленький стол", "маленьком столе"},
+		{"синяя полка", "синюю полку", "синей полке"},
+		{"ключи от двери", "ключи от двери", "ключах от двери"},
+	}
+	for _, c := range cases {
+		if acc := inflect(c.name, caseAcc); acc != c.acc {
+			t.Errorf("%s: accusative %s, expected %s", c.name, acc, c.acc)
+		}
+		if prep := inflect(c.name, casePrep); prep != c.prep {
+			t.Errorf("%s: prepositional %s, expected %s", c.name, prep, c.prep)
+		}
+		if nom := inflect(c.name, caseNom); nom != c.name {
+			t.Errorf("%s: nominative %s", c.name, nom)
+		}
+	}
+}
+
+func TestInflectIns(t *testing.T) {
+	cases := map[string]string{
+		"сосед":          "соседом",
+		"кот":            "котом",
+		"сторож":         "сторожем",
+		"учитель":        "учителем",
+		"мама":           "мамой",
+		"тетя":           "тетей",
+		"добрый сосед":   "добрым соседом",
+		"младшая сестра": "младшей сестрой",
+	}
+	for name, ins := range cases {
+		if res := inflect(name, caseIns); res != ins {
+			t.Errorf("%s: instrumental %s, expected %s", name, res, ins)
+		}
+	}
+}
+
+func TestNounForms(t *testing.T) {
+	f := Furniture{Name: "шкаф", Forms: nounForms{Prep: "шкафу"}, Preposition: "в"}
+	if res := f.preposition() + " " + nameCase(f, casePrep); res != "в шкафу" {
+		t.Error("unexpected:", res)
+	}
+	if res := nameCase(f, caseAcc); res != "шкаф" {
+		t.Error("unexpected:", res)
+	}
+	th := Thing{Name: "ключ", Forms: nounForms{Nom: "ключ от дома"}}
+	if res := nameCase(th, caseNom); res != "ключ от дома" {
+		t.Error("unexpected:", res)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// itemConfig - описание предмета, предметы без описания - обычные вещи
+// capacity - сколько предметов можно унести, если надеть эту вещь
+type itemConfig struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Capacity    int       `json:"capacity"`
+	Forms       nounForms `json:"forms"`
+}
+
+func (w *World) newThing(name string) *Thing {
+	item := w.items[name]
+	return &Thing{Name: name, Description: item.Description, Capacity: item.Capacity, Forms: item.Forms}
+}
+
+func (w *World) newThings(names []string) []*Thing {
+	things := make([]*Thing, 0, len(names))
+	for _, name := range names {
+		things = append(things, w.newThing(name))
+	}
+	return things
+}
+
+func removeThing(things []*Thing, name string) ([]*Thing, *Thing) {
+	for i, t := range things {
+		if t.getName() == name {
+			return append(things[:i:i], things[i+1:]...), t
+		}
+	}
+	return things, nil
+}
+
+// takeThing забирает предмет с мебели в комнате
+func (r *Room) takeThing(name string) *Thing {
+	for i := range r.FurnitureInIt {
+		f := &r.FurnitureInIt[i]
+		for j, t := range f.ThingsOnIt {
+			if t.getName() != name {
+				continue
+			}
+			last := len(f.ThingsOnIt) - 1
+			f.ThingsOnIt[j] = f.ThingsOnIt[last]
+			f.ThingsOnIt = f.ThingsOnIt[:last]
+			if len(f.ThingsOnIt) == 0 {
+				f.ThingsOnIt = nil
+			}
+			return t
+		}
+	}
+	return nil
+}
+
+func (r *Room) findFurniture(name string) *Furniture {
+	for i := range r.FurnitureInIt {
+		if r.FurnitureInIt[i].getName() == name {
+			return &r.FurnitureInIt[i]
+		}
+	}
+	return nil
+}
+
+// unlimited - столько можно унести по старому правилу, когда что-то надето
+const unlimited = -1
+
+// capacityConfigured - задана ли вместимость хоть у одного предмета
+// если нет, действует старое правило: надел рюкзак - можно брать сколько угодно
+func (w *World) capacityConfigured() bool {
+	for _, item := range w.items {
+		if item.Capacity != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// capacity - сколько предметов можно унести в том, что надето, unlimited - без ограничений
+func (p *Player) capacity() int {
+	return p.capacityOf(p.FitOn)
+}
+
+func (p *Player) capacityOf(worn []*Thing) int {
+	if p.backpackRule {
+		if len(worn) != 0 {
+			return unlimited
+		}
+		return 0
+	}
+	res := 0
+	for _, t := range worn {
+		res += t.Capacity
+	}
+	return res
+}
+
+func (p *Player) hasRoom() bool {
+	capacity := p.capacity()
+	return capacity == unlimited || len(p.Inventory) < capacity
+}
+
+func (p *Player) showInventory() string {
+	worn := "ничего не надето"
+	if len(p.FitOn) != 0 {
+		worn = "надето: " + strings.Join(thingNames(p.FitOn), ", ")
+	}
+	carried := "инвентарь пуст"
+	if len(p.Inventory) != 0 {
+		carried = "в инвентаре: " + strings.Join(thingNames(p.Inventory), ", ")
+	}
+	places := "места: " + strconv.Itoa(len(p.Inventory)) + "/" + strconv.Itoa(p.capacity())
+	if p.capacity() == unlimited {
+		places = "места: без ограничений"
+	}
+	return worn + ". " + carried + ". " + places
+}
+
+func (p *Player) putOn(item string, furniture string) string {
+	f := p.Place.findFurniture(furniture)
+	if f == nil {
+		return "нет такого места - " + furniture
+	}
+	rest, thing := removeThing(p.Inventory, item)
+	if thing == nil {
+		return "нет предмета в инвентаре - " + item
+	}
+	p.Inventory = rest
+	f.addThing(thing)
+	return "вы положили " + nameCase(thing, caseAcc) + " " + f.preposition() + " " + nameCase(f, caseAcc)
+}
+
+// takeOff снимает надетую вещь и кладет ее на первую мебель в комнате
+// снять вещь нельзя, если без нее инвентарь не поместится
+func (p *Player) takeOff(item string) string {
+	idx := -1
+	for i, t := range p.FitOn {
+		if t.getName() == item {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		return "на тебе нет - " + item
+	}
+	thing := p.FitOn[idx]
+	rest, _ := removeThing(p.FitOn, item)
+	if capacity := p.capacityOf(rest); capacity != unlimited && len(p.Inventory) > capacity {
+		return "нельзя снять " + item + ", сначала выложи вещи"
+	}
+	if len(p.Place.FurnitureInIt) == 0 {
+		return "некуда положить " + item
+	}
+	p.FitOn = rest
+	p.Place.FurnitureInIt[0].addThing(thing)
+	return "вы сняли: " + nameCase(thing, caseNom)
+}
+
+// has - есть ли предмет в инвентаре
+func (p *Player) has(name string) bool {
+	_, thing := removeThing(p.Inventory, name)
+	return thing != nil
+}
+
+// findThing ищет предмет у игрока и в комнате
+func (p *Player) findThing(name string) *Thing {
+	for _, things := range [][]*Thing{p.Inventory, p.FitOn} {
+		for _, t := range things {
+			if t.getName() == name {
+				return t
+			}
+		}
+	}
+	for _, f := range p.Place.FurnitureInIt {
+		for _, t := range f.ThingsOnIt {
+			if t.getName() == name {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Player) inspect(item string) string {
+	thing := p.findThing(item)
+	if thing == nil {
+		return "нет такого"
+	}
+	res := thing.Description
+	if res == "" {
+		res = "ничего особенного"
+	}
+	if thing.Capacity != 0 {
+		res += ", вмещает предметов: " + strconv.Itoa(thing.Capacity)
+	}
+	return thing.getName() + " - " + res
+}
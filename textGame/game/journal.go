@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	undoVerb       = "отменить"
+	maxUndo        = 100
+	maxJournal     = 10000
+	maxJournalLine = 1 << 20
+)
+
+// readOnlyVerbs не меняют мир, поэтому перед ними состояние не запоминается
+var readOnlyVerbs = map[string]bool{
+	"осмотреться": true,
+	"инвентарь":   true,
+	"осмотреть":   true,
+	"задачи":      true,
+	"сохранить":   true,
+	"помощь":      true,
+	undoVerb:      true,
+}
+
+// journalEntry - команда игрока и ответ на нее
+// at - время от создания мира, по нему при повторе срабатывают события по часам
+// skipped есть только у первой записи обрезанного журнала: сколько команд до нее выброшено
+type journalEntry struct {
+	Player  string        `json:"player,omitempty"`
+	Command string        `json:"command,omitempty"`
+	Answer  string        `json:"answer,omitempty"`
+	At      time.Duration `json:"at,omitempty"`
+	Skipped int           `json:"skipped,omitempty"`
+}
+
+// undoEntry - состояние мира перед командой игрока
+// seq - номер изменения мира, отменить можно только самое последнее
+type undoEntry struct {
+	command string
+	seq     int
+	state   *worldState
+}
+
+// record дописывает команду в журнал, хранятся последние maxJournal записей
+// сколько выброшено, запоминается: такой журнал уже нельзя проиграть на новом мире
+func (w *World) record(entry journalEntry) {
+	if len(w.journal) == maxJournal {
+		w.journal = w.journal[1:]
+		w.journalSkipped++
+	}
+	w.journal = append(w.journal, entry)
+}
+
+// exportJournal - копия журнала для выгрузки, у обрезанного первой идет запись skipped
+func (w *World) exportJournal() []journalEntry {
+	journal := make([]journalEntry, 0, len(w.journal)+1)
+	if w.journalSkipped != 0 {
+		journal = append(journal, journalEntry{Skipped: w.journalSkipped})
+	}
+	return append(journal, w.journal...)
+}
+
+// remember запоминает состояние мира перед командой игрока, чтобы ее можно было отменить
+func (w *World) remember(player, command string, state *worldState) {
+	if w.undo == nil {
+		w.undo = make(map[string][]undoEntry)
+	}
+	stack := w.undo[player]
+	if len(stack) == maxUndo {
+		stack = stack[1:]
+	}
+	w.seq++
+	w.undo[player] = append(stack, undoEntry{command, w.seq, state})
+}
+
+// undoLast возвращает мир к состоянию до последней команды игрока
+// если после нее мир менял кто-то другой, отменять нельзя - пропали бы и его ходы
+func (w *World) undoLast(player string) string {
+	stack := w.undo[player]
+	if len(stack) == 0 {
+		return "нечего отменять"
+	}
+	last := stack[len(stack)-1]
+	if last.seq != w.seq {
+		return "нельзя отменить: после тебя ходили другие"
+	}
+	if err := w.restore(last.state); err != nil {
+		return "не удалось отменить"
+	}
+	w.undo[player] = stack[:len(stack)-1]
+	w.seq = 0
+	for _, entries := range w.undo {
+		if len(entries) != 0 {
+			w.seq = max(w.seq, entries[len(entries)-1].seq)
+		}
+	}
+	return "отменено: " + last.command
+}
+
+// replayError - при повторе журнала игра ответила не так, как в записи
+type replayError struct {
+	step   int
+	entry  journalEntry
+	answer string
+}
+
+func (e replayError) Error() string {
+	return fmt.Sprintf("step %d, player %s\n\tcmd: %s\n\tresult:   %s\n\texpected: %s",
+		e.step, e.entry.Player, e.entry.Command, e.answer, e.entry.Answer)
+}
+
+// replay заново проигрывает журнал на новом мире и сверяет ответы
+// часы мира подменяются временем из журнала, поэтому события срабатывают так же
+func replay(journal []journalEntry, newGame func() (*World, error)) (*World, []replayError, error) {
+	w, err := newGame()
+	if err != nil {
+		return nil, nil, err
+	}
+	at := time.Duration(0)
+	w.clock = func() time.Time { return w.started.Add(at) }
+	fails := make([]replayError, 0)
+	for i, entry := range journal {
+		if entry.Skipped != 0 {
+			return nil, nil, fmt.Errorf("journal misses the first %d commands, it can't be replayed on a new world", entry.Skipped)
+		}
+		at = entry.At
+		if answer := w.handleCommand(entry.Player, entry.Command); answer != entry.Answer {
+			fails = append(fails, replayError{i + 1, entry, answer})
+		}
+	}
+	return w, fails, nil
+}
+
+// writeJournal пишет журнал по записи в строке, как json lines
+func writeJournal(out io.Writer, journal []journalEntry) error {
+	enc := json.NewEncoder(out)
+	for _, entry := range journal {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readJournal(in io.Reader) ([]journalEntry, error) {
+	journal := make([]journalEntry, 0)
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 4096), maxJournalLine)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		entry := journalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("bad journal line %d: %w", line, err)
+		}
+		journal = append(journal, entry)
+	}
+	return journal, scanner.Err()
+}
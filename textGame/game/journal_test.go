@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUndo(t *testing.T) {
+	w, err := defaultGame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []gameStep{
+		{"отменить", "нечего отменять"},
+		{"идти коридор", "ничего интересного. можно пройти - кухня, комната, улица"},
+		{"идти комната", "ты в своей комнате. можно пройти - коридор"},
+		{"надеть рюкзак", "вы надели: рюкзак"},
+		{"взять ключи", "предмет добавлен в инвентарь: ключи"},
+		{"отменить", "отменено: взять ключи"},
+		{"инвентарь", "надето: рюкзак. инвентарь пуст. места: 0/5"},
+		{"прыгнуть", "неизвестная команда"},
+		// неудачная команда ничего не поменяла, отменять ее нечего
+		{"снять шапку", "на тебе нет - шапку"},
+		{"undo", "отменено: надеть рюкзак"},
+		{"осмотреться", "на столе: ключи, конспекты, на стуле: рюкзак. можно пройти - коридор"},
+		{"отменить", "отменено: идти комната"},
+		{"осмотреться", "пустая комната. можно пройти - кухня, комната, улица"},
+	}
+	checkSteps(t, w, defaultPlayer, steps)
+
+	// у каждого игрока свои отмены, чужой ход отменить нельзя
+	checkSteps(t, w, "вася", []gameStep{{"идти коридор", "ничего интересного. можно пройти - кухня, комната, улица"}})
+	checkSteps(t, w, defaultPlayer, []gameStep{{"отменить", "нельзя отменить: после тебя ходили другие"}})
+	checkSteps(t, w, "вася", []gameStep{{"отменить", "отменено: идти коридор"}, {"отменить", "нечего отменять"}})
+	checkSteps(t, w, defaultPlayer, []gameStep{{"отменить", "отменено: идти коридор"}})
+	if len(w.journal) != len(steps)+5 {
+		t.Error("journal has", len(w.journal), "entries, expected", len(steps)+5)
+	}
+}
+
+func TestUndoLimits(t *testing.T) {
+	w, err := newWorldFromFile("levels/roommate.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := w.started
+	w.clock = func() time.Time { return now }
+
+	// часы событий откатываются вместе с миром
+	now = now.Add(time.Minute)
+	w.handleCommand(defaultPlayer, "идти коридор")
+	if w.events[1].last != now {
+		t.Fatal("event did not fire")
+	}
+	w.handleCommand(defaultPlayer, "отменить")
+	if w.events[1].last != w.started {
+		t.Error("event timer is not restored by undo")
+	}
+
+	for i := 0; i < maxUndo+maxJournal; i++ {
+		w.handleCommand(defaultPlayer, []string{"идти коридор", "идти кухня"}[i%2])
+	}
+	if len(w.undo[defaultPlayer]) != maxUndo || len(w.journal) != maxJournal {
+		t.Error("undo and journal are not capped:", len(w.undo[defaultPlayer]), len(w.journal))
+	}
+
+	// обрезанный журнал говорит, сколько в нем не хватает, и не проигрывается
+	journal := w.exportJournal()
+	if len(journal) != maxJournal+1 || journal[0].Skipped != maxUndo+2 {
+		t.Fatal("trimmed journal does not report skipped commands:", len(journal), journal[0])
+	}
+	if _, _, err := replay(journal, defaultGame); err == nil {
+		t.Error("expected error for a trimmed journal")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	newGame := func() (*World, error) {
+		return newWorldFromFile("levels/roommate.json")
+	}
+	w, err := newGame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := w.started
+	w.clock = func() time.Time { return now }
+	commands := []string{
+		"идти коридор", "говорить сосед", "идти кухня", "отменить", "идти комната",
+		"взять ключи", "идти коридор", "идти кухня", "осмотреться", "осмотреться",
+	}
+	for i, command := range commands {
+		if i == 8 {
+			now = now.Add(2 * time.Minute)
+		}
+		w.handleCommand(defaultPlayer, command)
+		w.handleCommand("вася", "осмотреться")
+	}
+
+	var buf bytes.Buffer
+	if err := writeJournal(&buf, w.journal); err != nil {
+		t.Fatal(err)
+	}
+	journal, err := readJournal(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, fails, err := replay(journal, newGame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fail := range fails {
+		t.Error(fail)
+	}
+	if answer := replayed.handleCommand(defaultPlayer, "осмотреться"); answer != w.handleCommand(defaultPlayer, "осмотреться") {
+		t.Error("replayed world differs:", answer)
+	}
+
+	journal[4].Answer = "не тот ответ"
+	if _, fails, _ = replay(journal, newGame); len(fails) != 1 || fails[0].step != 5 {
+		t.Error("expected mismatch at step 5, got", fails)
+	}
+}
+
+func TestServerJournal(t *testing.T) {
+	gs := newGameServer(defaultGame, time.Minute)
+	ts := httptest.NewServer(gs.routes())
+	defer ts.Close()
+
+	created := map[string]string{}
+	postJSON(t, ts.URL+"/session", "", &created)
+	base := ts.URL + "/session/" + created["id"]
+	postJSON(t, base+"/command", `{"command": "идти коридор"}`, nil)
+	postJSON(t, base+"/command", `{"player": "вася", "command": "взять чай"}`, nil)
+
+	resp, err := http.Get(base + "/journal") //nolint:gosec
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	journal, err := readJournal(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(journal) != 2 || journal[1].Player != "вася" || journal[1].Answer != "некуда класть" {
+		t.Error("unexpected journal:", journal)
+	}
+	if _, fails, err := replay(journal, defaultGame); err != nil || len(fails) != 0 {
+		t.Error("replay failed:", fails, err)
+	}
+}
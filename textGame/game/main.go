@@ -1,86 +1,71 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
 	"strings"
 )
 
-var player = Player{"ты", Room{}, nil, nil, nil, false}
-
 /*
 код писать в этом файле
 наверняка у вас будут какие-то структуры с методами, глобальные перменные ( тут можно ), функции
 */
 type Nameable interface {
 	getName() string
-}
-
-func nameDifGender(n Nameable) string {
-	switch n.getName() {
-	case "стол":
-		return "столе"
-	case "стул":
-		return "стуле"
-	}
-	return n.getName()
+	getForms() nounForms
 }
 
 type Player struct {
-	Name       string
-	Place      Room
-	Tasks      []string
-	Inventory  []*Thing
-	FitOn      []*Thing
-	ableToTake bool
+	Name      string
+	Place     *Room
+	Tasks     []*Quest
+	Inventory []*Thing
+	FitOn     []*Thing
+	news      []string
+	// в мире нет вместимости предметов, брать можно, если хоть что-то надето
+	backpackRule bool
 }
 
-func (p *Player) addTask(task string) {
-	p.Tasks = append(p.Tasks, task)
-}
-
-func (p *Player) setPlace(place Room) {
+func (p *Player) setPlace(place *Room) {
 	p.Place = place
 }
 
 func (p *Player) playerMove(name string) string {
-	idx := 0
-	flag := false
-	res := ""
-	i := 0
-	for i = range p.Place.Doors {
-		if p.Place.Doors[i].Name == name {
+	var next *Room
+	for _, room := range p.Place.NextRooms {
+		if room.getName() == name {
+			next = room
 			break
 		}
 	}
-	if p.Place.Doors[i].Status {
-		for idx < len(p.Place.NextRooms) {
-			if p.Place.NextRooms[idx].getName() == name {
-				flag = true
-				break
-			}
-			idx++
-		}
-	} else {
-		return "дверь закрыта"
+	if next == nil {
+		return "нет пути в " + name
 	}
-	if flag {
-		p.Place = *p.Place.NextRooms[idx]
-	} else {
+	// дверь ищется по комнатам, которые она соединяет, а не по имени
+	door := p.Place.doorTo(next)
+	if door == nil {
 		return "нет пути в " + name
 	}
-	res = p.Place.InfoMoved + ". " + p.ableToGo()
-	return res
+	if !door.Status {
+		return "дверь закрыта"
+	}
+	p.Place = next
+	return p.Place.InfoMoved + ". " + p.ableToGo()
 }
 
 func (p *Player) ableToGo() string {
 	res := "можно пройти - "
 	for i := 0; i < len(p.Place.NextRooms); i++ {
-		res += player.Place.NextRooms[i].getName() + ", "
+		res += p.Place.NextRooms[i].getName() + ", "
 	}
 	res = res[:len(res)-2]
 	return res
 }
 
-func (r *Room) getInfo() string {
+func (r *Room) getInfo(p *Player) string {
 	res := r.Info
 	flag := false
 	if res != "" {
@@ -89,11 +74,11 @@ func (r *Room) getInfo() string {
 	if len(r.FurnitureInIt) != 0 {
 		for i := range r.FurnitureInIt {
 			if len(r.FurnitureInIt[i].ThingsOnIt) != 0 {
-				res += "на " + nameDifGender(r.FurnitureInIt[i]) + ": "
+				res += r.FurnitureInIt[i].preposition() + " " + nameCase(r.FurnitureInIt[i], casePrep) + ": "
 				if len(r.FurnitureInIt[i].ThingsOnIt) != 0 {
 					flag = true
 					for j := range r.FurnitureInIt[i].ThingsOnIt {
-						res += nameDifGender(r.FurnitureInIt[i].ThingsOnIt[j])
+						res += nameCase(r.FurnitureInIt[i].ThingsOnIt[j], caseNom)
 						if j != len(r.FurnitureInIt[i].ThingsOnIt)-1 {
 							res += ", "
 						} else if i != len(r.FurnitureInIt) {
@@ -108,13 +93,13 @@ func (r *Room) getInfo() string {
 		}
 	}
 	if !flag {
-		res = "пустая комната  "
+		res = "пустая комната, "
 	}
-	if r.Name == "кухня" {
-		res += player.Tasks[0]
+	if r.ShowTasks {
+		res += p.tasksInfo()
 	}
 	res = res[:len(res)-2] + ". "
-	res += player.ableToGo()
+	res += p.ableToGo()
 	return res
 }
 
@@ -122,98 +107,33 @@ func (r *Room) getInfo() string {
 // на столе: ключи, конспекты, на стуле: рюкзак. можно пройти - коридор
 func (p *Player) lookUp() string {
 	res := ""
-	res = p.Place.getInfo()
+	res = p.Place.getInfo(p)
 
 	return res
 }
 
 func (p *Player) fitOnYourself(item string) string {
-	flag := false
-	res := "вы надели: "
-	for i := 0; i < len(p.Place.FurnitureInIt); i++ {
-		for j := 0; j < len(p.Place.FurnitureInIt[i].ThingsOnIt); j++ {
-			if p.Place.FurnitureInIt[i].ThingsOnIt[j].getName() == item {
-				p.ableToTake = true
-				flag = true
-				p.FitOn = append(p.FitOn, p.Place.FurnitureInIt[i].ThingsOnIt[j])
-				if len(p.Place.FurnitureInIt[i].ThingsOnIt) == 1 {
-					p.Place.FurnitureInIt[i].ThingsOnIt = nil
-				} else {
-					p.Place.FurnitureInIt[i].ThingsOnIt[j] = p.Place.FurnitureInIt[i].ThingsOnIt[len(p.Place.FurnitureInIt[i].ThingsOnIt)-1]
-					tmp := Thing{"", nil}
-					p.Place.FurnitureInIt[i].ThingsOnIt[len(p.Place.FurnitureInIt[i].ThingsOnIt)-1] = &tmp
-					p.Place.FurnitureInIt[i].ThingsOnIt = p.Place.FurnitureInIt[i].ThingsOnIt[:len(p.Place.FurnitureInIt[i].ThingsOnIt)-1]
-				}
-			}
-		}
-	}
-	if !flag {
+	thing := p.Place.takeThing(item)
+	if thing == nil {
 		return "нет такого"
 	}
-	res += p.FitOn[len(p.FitOn)-1].getName()
-
-	return res
-
+	p.FitOn = append(p.FitOn, thing)
+	return "вы надели: " + nameCase(thing, caseNom)
 }
 
 func (p *Player) putInInventory(item string) string {
-	res := ""
-	flag := false
-	if p.ableToTake {
-		for i := 0; i < len(p.Place.FurnitureInIt); i++ {
-			for j := 0; j < len(p.Place.FurnitureInIt[i].ThingsOnIt); j++ {
-				if p.Place.FurnitureInIt[i].ThingsOnIt[j].getName() == item {
-					flag = true
-					p.Inventory = append(p.Inventory, p.Place.FurnitureInIt[i].ThingsOnIt[j])
-					if len(p.Place.FurnitureInIt[i].ThingsOnIt) == 1 {
-						p.Place.FurnitureInIt[i].ThingsOnIt = nil
-					} else {
-						p.Place.FurnitureInIt[i].ThingsOnIt[j] = p.Place.FurnitureInIt[i].ThingsOnIt[len(p.Place.FurnitureInIt[i].ThingsOnIt)-1]
-						tmp := Thing{"", nil}
-						p.Place.FurnitureInIt[i].ThingsOnIt[len(p.Place.FurnitureInIt[i].ThingsOnIt)-1] = &tmp
-						p.Place.FurnitureInIt[i].ThingsOnIt = p.Place.FurnitureInIt[i].ThingsOnIt[:len(p.Place.FurnitureInIt[i].ThingsOnIt)-1]
-					}
-				}
-			}
-		}
-		if !flag {
-			return "нет такого"
-		} else {
-			res = "предмет добавлен в инвентарь: " + p.Inventory[len(p.Inventory)-1].getName()
-			p.Tasks[0] = "надо идти в универ. "
-		}
-
-	} else {
+	if p.capacity() == 0 {
 		return "некуда класть"
 	}
-	return res
-}
-
-func (p *Player) useThing(thing string, item string) string {
-	res := ""
-	flag := false
-	able := false
-	for i := range p.Inventory {
-		if p.Inventory[i].getName() == thing {
-			able = true
-		}
-	}
-	if able {
-		for j := range p.Place.Doors {
-			if p.Place.Doors[j].Name == item {
-				p.Place.Doors[j].Status = true
-				flag = true
-				res = "дверь открыта"
-			}
-		}
-	} else {
-		return "нет предмета в инвентаре - " + thing
+	if !p.hasRoom() {
+		return "в инвентаре нет места"
 	}
-
-	if !flag {
-		res = "не к чему применить"
+	thing := p.Place.takeThing(item)
+	if thing == nil {
+		return "нет такого"
 	}
-	return res
+	p.Inventory = append(p.Inventory, thing)
+	return "предмет добавлен в инвентарь: " + nameCase(thing, caseNom)
 }
 
 type Door struct {
@@ -229,16 +149,42 @@ type Room struct {
 	Doors         []*Door
 	Info          string
 	InfoMoved     string
+	ShowTasks     bool
+	Forms         nounForms
+	Preposition   string
 }
 
 func (r Room) getName() string {
 	return r.Name
 }
 
+func (r Room) getForms() nounForms {
+	return r.Forms
+}
+
+// preposition - "в коридор", но "на кухню"
+func (r Room) preposition() string {
+	if r.Preposition == "" {
+		return "в"
+	}
+	return r.Preposition
+}
+
 func (r *Room) addRoom(room *Room) {
 	r.NextRooms = append(r.NextRooms, room)
 }
 
+// doorTo - дверь между комнатой и соседней, nil если такой двери нет
+func (r *Room) doorTo(next *Room) *Door {
+	for _, door := range r.Doors {
+		a, b := door.RoomConnect[0], door.RoomConnect[1]
+		if (a == r && b == next) || (a == next && b == r) {
+			return door
+		}
+	}
+	return nil
+}
+
 func (r *Room) addFurniture(furniture ...Furniture) {
 	r.FurnitureInIt = append(r.FurnitureInIt, furniture...)
 
@@ -260,34 +206,184 @@ func roomConnectSet(door *Door, room1 *Room, room2 *Room) {
 	room2.Doors = append(room2.Doors, door)
 }
 
+// roomConnectOneWay - переход из room1 в room2 без обратного, остальные переходы room1 остаются
+func roomConnectOneWay(door *Door, room1 *Room, room2 *Room) {
+	room1.addRoom(room2)
+	room1.Doors = append(room1.Doors, door)
+	room2.Doors = append(room2.Doors, door)
+}
+
 type Furniture struct {
-	Name       string
-	ThingsOnIt []*Thing
+	Name        string
+	ThingsOnIt  []*Thing
+	Forms       nounForms
+	Preposition string
 }
 
 func (f Furniture) getName() string {
 	return f.Name
 }
 
+func (f Furniture) getForms() nounForms {
+	return f.Forms
+}
+
+// preposition - "на столе", но "в шкафу"
+func (f Furniture) preposition() string {
+	if f.Preposition == "" {
+		return "на"
+	}
+	return f.Preposition
+}
+
 func (f *Furniture) addThing(thing ...*Thing) {
 	f.ThingsOnIt = append(f.ThingsOnIt, thing...)
 }
 
 type Thing struct {
-	Name     string
-	ActionTo []Furniture
+	Name        string
+	ActionTo    []Furniture
+	Description string
+	Capacity    int
+	Forms       nounForms
 }
 
 func (t Thing) getName() string {
 	return t.Name
 }
 
+func (t Thing) getForms() nounForms {
+	return t.Forms
+}
+
 func main() {
 	/*
 		в этой функции можно ничего не писать
 		но тогда у вас не будет работать через go run main.go
 		очень круто будет сделать построчный ввод команд тут, хотя это и не требуется по заданию
 	*/
+	worldPath := flag.String("world", "", "файл с описанием мира, по умолчанию встроенный")
+	scriptPath := flag.String("script", "", "файл со сценарием: команда<TAB>ожидаемый ответ")
+	playerName := flag.String("player", defaultPlayer, "имя игрока")
+	saveDir := flag.String("saves", defaultSaveDir, "папка для сохранений")
+	httpAddr := flag.String("http", "", "адрес http сервера, например :8080")
+	sessionTTL := flag.Duration("ttl", defaultSessionTTL, "через сколько удалять неактивную сессию")
+	maxSessions := flag.Int("sessions", defaultMaxSessions, "сколько сессий может быть на сервере одновременно")
+	origins := flag.String("origin", "", "с каких страниц, кроме своего хоста, можно открыть websocket, через запятую")
+	validate := flag.Bool("validate", false, "проверить мир и вывести найденные ошибки")
+	dotPath := flag.String("dot", "", "выгрузить граф комнат в формате Graphviz, - для stdout")
+	journalPath := flag.String("journal", "", "файл, куда записать журнал команд и ответов")
+	replayPath := flag.String("replay", "", "проиграть журнал и сверить ответы")
+	flag.Parse()
+
+	newGame := func() (*World, error) {
+		w, err := newWorldFromFile(*worldPath)
+		if err != nil {
+			return nil, err
+		}
+		w.saveDir = *saveDir
+		return w, nil
+	}
+
+	if *validate || *dotPath != "" {
+		w, err := newGame()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *dotPath != "" {
+			if err := exportDOT(w, *dotPath); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *validate {
+			problems := w.validate()
+			for _, problem := range problems {
+				fmt.Println(problem)
+			}
+			if len(problems) != 0 {
+				os.Exit(1)
+			}
+			fmt.Println("ok")
+		}
+		return
+	}
+
+	if *httpAddr != "" {
+		gs := newGameServer(newGame, *sessionTTL)
+		gs.maxSessions = *maxSessions
+		if *origins != "" {
+			gs.origins = strings.Split(*origins, ",")
+		}
+		if err := serveHTTP(*httpAddr, gs); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *replayPath != "" {
+		file, err := os.Open(*replayPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		journal, err := readJournal(file)
+		file.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		_, fails, err := replay(journal, newGame)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, fail := range fails {
+			fmt.Println(fail)
+		}
+		if len(fails) != 0 {
+			os.Exit(1)
+		}
+		fmt.Println("ok")
+		return
+	}
+
+	if *scriptPath != "" {
+		file, err := os.Open(*scriptPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		fails, err := runScript(file, newGame, *playerName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, fail := range fails {
+			fmt.Println(fail)
+		}
+		if len(fails) != 0 {
+			os.Exit(1)
+		}
+		fmt.Println("ok")
+		return
+	}
+
+	w, err := newGame()
+	if err != nil {
+		log.Fatal(err)
+	}
+	r := &repl{world: w, player: *playerName}
+	if err := r.run(os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+	if *journalPath != "" {
+		file, err := os.Create(*journalPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := writeJournal(file, w.exportJournal()); err != nil {
+			log.Fatal(err)
+		}
+		if err := file.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
 
 func initGame() {
@@ -295,57 +391,77 @@ func initGame() {
 		эта функция инициализирует игровой мир - все команты
 		если что-то было - оно корректно перезатирается
 	*/
-	player = Player{"ты", Room{}, nil, nil, nil, false}
-	var hall = Room{"коридор", nil, nil, nil, "ничего интересного", "ничего интересного"}
-	var room = Room{"комната", nil, nil, nil, "", "ты в своей комнате"}
-	var outside = Room{"улица", nil, nil, nil, "", "на улице весна"}
-	var inside = Room{"домой", nil, nil, nil, "", ""}
-	var kitchen = Room{"кухня", nil, nil, nil, "ты находишься на кухне,", "кухня, ничего интересного"}
-	var doorKitchenHall = Door{"кухня", true, [2]*Room{&hall, &kitchen}}
-	var doorRoomHall = Door{"комната", true, [2]*Room{&hall, &room}}
-	var doorOutside = Door{"дверь", false, [2]*Room{&outside, &inside}}
-
-	roomConnect(&doorKitchenHall, &hall, &kitchen)
-	roomConnect(&doorRoomHall, &hall, &room)
-	roomConnect(&doorOutside, &hall, &outside)
-	roomConnectSet(&doorOutside, &outside, &inside)
-
-	var tableKithcen = Furniture{"стол", nil}
-	tea := Thing{"чай", []Furniture{tableKithcen}}
-	tableKithcen.addThing(&tea)
-	kitchen.addFurniture(tableKithcen)
-	tableRoom := Furniture{"стол", nil}
-	keys := Thing{"ключи", []Furniture{tableRoom}}
-	conspects := Thing{"конспекты", []Furniture{tableRoom}}
-	tableRoom.addThing(&keys, &conspects)
-	backpack := Thing{"рюкзак", nil}
-	chairRoom := Furniture{"стул", []*Thing{&backpack}}
-	room.addFurniture(tableRoom, chairRoom)
-
-	player.addTask("надо собрать рюкзак и идти в универ. ")
-
-	player.setPlace(kitchen)
+	if err := initGameFromFile(""); err != nil {
+		panic(err)
+	}
+}
+
+// initGameFromFile загружает мир из файла с описанием уровня
+func initGameFromFile(path string) error {
+	w, err := newWorldFromFile(path)
+	if err != nil {
+		return err
+	}
+	w.addPlayer(defaultPlayer)
+	world = w
+	return nil
+}
+
+// newWorldFromFile собирает мир из файла, пустой путь - встроенный мир
+func newWorldFromFile(path string) (*World, error) {
+	var cfg *worldConfig
+	var err error
+	if path == "" {
+		cfg, err = parseWorld(defaultWorld)
+	} else {
+		cfg, err = loadWorld(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newWorld(cfg)
 }
 
+// handleCommand выполняет команду за игрока по умолчанию
 func handleCommand(command string) string {
+	return world.handleCommand(defaultPlayer, command)
+}
+
+func (w *World) handleCommand(playerName string, command string) string {
 	/*
 		данная функция принимает команду от "пользователя"
 		и наверняка вызывает какой-то другой метод или функцию у "мира" - списка комнат
 	*/
-	commands := strings.Split(command, " ")
-	switch commands[0] {
-	case "осмотреться":
-		return player.lookUp()
-	case "идти":
-		return player.playerMove(commands[1])
-	case "надеть":
-		return player.fitOnYourself(commands[1])
-	case "взять":
-		return player.putInInventory(commands[1])
-	case "применить":
-		return player.useThing(commands[1], commands[2])
-	default:
-		return "неизвестная команда"
+	now := w.clock()
+	v, args, answer := parseCommand(command, func(name string) bool {
+		player, ok := w.Players[playerName]
+		return ok && player.has(name)
+	})
+	// до часов, чтобы отмена откатывала и то, что сработало по ним
+	var before *worldState
+	if v != nil && !readOnlyVerbs[v.name] {
+		before = w.snapshot()
 	}
-
+	w.tick(now)
+	w.turn++
+	if v != nil {
+		player := w.addPlayer(playerName)
+		var ticked *worldState
+		if before != nil {
+			ticked = w.snapshot()
+		}
+		answer = v.run(w, player, args)
+		// команда, которая ничего не поменяла (например, не удалась), не отменяется
+		if before != nil && !reflect.DeepEqual(ticked, w.snapshot()) {
+			w.remember(playerName, command, before)
+		}
+	}
+	if player, ok := w.Players[playerName]; ok {
+		messages := append(player.updateQuests(), player.takeNews()...)
+		if len(messages) != 0 {
+			answer += ". " + strings.Join(messages, ". ")
+		}
+	}
+	w.record(journalEntry{Player: playerName, Command: command, Answer: answer, At: now.Sub(w.started)})
+	return answer
 }
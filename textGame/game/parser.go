@@ -0,0 +1,183 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// verb - команда игры: имя, синонимы, аргументы и обработчик
+// последний аргумент забирает все оставшиеся слова, так что "взять красный ключ" тоже работает.
+// первый аргумент из нескольких слов узнается по предметам игрока: "применить красный ключ дверь"
+type verb struct {
+	name     string
+	synonyms []string
+	args     []string
+	help     string
+	run      func(w *World, p *Player, args []string) string
+}
+
+func (v *verb) usage() string {
+	res := v.name
+	for _, arg := range v.args {
+		res += " <" + arg + ">"
+	}
+	return res
+}
+
+var verbs []*verb
+
+func init() {
+	verbs = []*verb{
+		{"осмотреться", []string{"посмотреть", "оглядеться"}, nil, "осмотреть комнату",
+			func(w *World, p *Player, args []string) string {
+				return p.lookUp() + w.othersInRoom(p)
+			}},
+		{"идти", []string{"пойти", "перейти"}, []string{"куда"}, "перейти в соседнюю комнату",
+			func(w *World, p *Player, args []string) string {
+				return p.playerMove(args[0])
+			}},
+		{"надеть", nil, []string{"предмет"}, "надеть предмет из комнаты",
+			func(w *World, p *Player, args []string) string {
+				return p.fitOnYourself(args[0])
+			}},
+		{"взять", []string{"подобрать"}, []string{"предмет"}, "положить предмет из комнаты в инвентарь",
+			func(w *World, p *Player, args []string) string {
+				return p.putInInventory(args[0])
+			}},
+		{"применить", []string{"использовать"}, []string{"предмет", "к чему"}, "применить предмет из инвентаря",
+			func(w *World, p *Player, args []string) string {
+				return w.useThing(p, args[0], args[1])
+			}},
+		{"инвентарь", nil, nil, "что надето и что в инвентаре",
+			func(w *World, p *Player, args []string) string {
+				return p.showInventory()
+			}},
+		{"положить", nil, []string{"предмет", "куда"}, "положить предмет из инвентаря на мебель",
+			func(w *World, p *Player, args []string) string {
+				return p.putOn(args[0], args[1])
+			}},
+		{"снять", nil, []string{"предмет"}, "снять надетую вещь",
+			func(w *World, p *Player, args []string) string {
+				return p.takeOff(args[0])
+			}},
+		{"осмотреть", nil, []string{"предмет"}, "описание предмета",
+			func(w *World, p *Player, args []string) string {
+				return p.inspect(args[0])
+			}},
+		{"говорить", []string{"поговорить"}, []string{"с кем"}, "поговорить с персонажем",
+			func(w *World, p *Player, args []string) string {
+				name := strings.TrimPrefix(strings.TrimPrefix(args[0], "с "), "со ")
+				return w.talk(p, name)
+			}},
+		{"задачи", nil, nil, "список задач",
+			func(w *World, p *Player, args []string) string {
+				return p.listQuests()
+			}},
+		{"сохранить", []string{"save"}, []string{"имя"}, "сохранить игру",
+			func(w *World, p *Player, args []string) string {
+				return w.saveGame(args[0])
+			}},
+		{"загрузить", []string{"load"}, []string{"имя"}, "загрузить игру",
+			func(w *World, p *Player, args []string) string {
+				return w.loadGame(args[0])
+			}},
+		{undoVerb, []string{"undo"}, nil, "отменить последнюю команду",
+			func(w *World, p *Player, args []string) string {
+				return w.undoLast(p.Name)
+			}},
+		{"помощь", []string{"help"}, nil, "список команд",
+			func(w *World, p *Player, args []string) string {
+				return helpText()
+			}},
+	}
+}
+
+func findVerb(name string) *verb {
+	for _, v := range verbs {
+		if v.name == name {
+			return v
+		}
+		for _, s := range v.synonyms {
+			if s == name {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+func helpText() string {
+	lines := make([]string, 0, len(verbs))
+	for _, v := range verbs {
+		line := v.usage() + " - " + v.help
+		if len(v.synonyms) != 0 {
+			line += " (" + strings.Join(v.synonyms, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// splitCommand делит команду на слова, текст в кавычках "" или «» - одно слово
+func splitCommand(command string) []string {
+	words := make([]string, 0)
+	word := make([]rune, 0)
+	inWord := false
+	var closing rune
+	for _, r := range command {
+		switch {
+		case closing != 0 && r == closing:
+			closing = 0
+		case closing != 0:
+			word = append(word, r)
+		case r == '"':
+			closing, inWord = '"', true
+		case r == '«':
+			closing, inWord = '»', true
+		case unicode.IsSpace(r):
+			// пустые кавычки "" - не слово
+			if inWord && len(word) != 0 {
+				words = append(words, string(word))
+			}
+			word, inWord = word[:0], false
+		default:
+			word, inWord = append(word, r), true
+		}
+	}
+	if inWord && len(word) != 0 {
+		words = append(words, string(word))
+	}
+	return words
+}
+
+// parseCommand находит команду и собирает ее аргументы
+// при неверном числе аргументов возвращает подсказку
+// known говорит, есть ли у игрока предмет с таким именем, по нему делятся лишние слова
+func parseCommand(command string, known func(string) bool) (*verb, []string, string) {
+	words := splitCommand(command)
+	if len(words) == 0 {
+		return nil, nil, "неизвестная команда"
+	}
+	v := findVerb(strings.ToLower(words[0]))
+	if v == nil {
+		return nil, nil, "неизвестная команда"
+	}
+	args := words[1:]
+	if len(args) < len(v.args) || (len(v.args) == 0 && len(args) != 0) {
+		return nil, nil, "использование: " + v.usage()
+	}
+	if len(args) > len(v.args) && len(v.args) > 1 && known != nil {
+		// первый аргумент - самое длинное начало, которое называет предмет игрока
+		for n := len(args) - len(v.args) + 1; n > 1; n-- {
+			if first := strings.Join(args[:n], " "); known(first) {
+				args = append([]string{first}, args[n:]...)
+				break
+			}
+		}
+	}
+	if len(args) > len(v.args) {
+		last := len(v.args) - 1
+		args = append(args[:last:last], strings.Join(args[last:], " "))
+	}
+	return v, args, ""
+}
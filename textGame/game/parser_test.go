@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	cases := []struct {
+		command string
+		words   []string
+	}{
+		{"", []string{}},
+		{"  идти   коридор ", []string{"идти", "коридор"}},
+		{`взять "красный ключ"`, []string{"взять", "красный ключ"}},
+		{"применить «красный ключ» дверь", []string{"применить", "красный ключ", "дверь"}},
+		{`взять "красный ключ`, []string{"взять", "красный ключ"}},
+		{`взять "" ключ ""`, []string{"взять", "ключ"}},
+	}
+	for _, c := range cases {
+		if words := splitCommand(c.command); !reflect.DeepEqual(words, c.words) {
+			t.Errorf("%q: got %q, expected %q", c.command, words, c.words)
+		}
+	}
+}
+
+const parserWorld = `{
+	"start": "комната",
+	"items": [{"name": "рюкзак", "capacity": 5}],
+	"rooms": [
+		{"name": "комната", "infoMoved": "комната", "furniture": [
+			{"name": "стол", "things": ["рюкзак", "красный ключ"]}
+		]},
+		{"name": "чулан", "infoMoved": "чулан"}
+	],
+	"doors": [{"name": "старая дверь", "rooms": ["комната", "чулан"]}],
+	"links": [{"door": "старая дверь", "from": "комната", "to": ["чулан"]}],
+	"rules": [
+		{"item": "красный ключ", "target": "старая дверь", "effects": [{"action": "openDoor"}], "answer": "дверь открыта"}
+	]
+}`
+
+func TestGrammar(t *testing.T) {
+	cfg, err := parseWorld([]byte(parserWorld))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWorld(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []gameStep{
+		{"идти", "использование: идти <куда>"},
+		{"применить ключ", "использование: применить <предмет> <к чему>"},
+		{"осмотреться вокруг", "использование: осмотреться"},
+		{"   ", "неизвестная команда"},
+		{"посмотреть", "на столе: рюкзак, красный ключ. можно пройти - чулан"},
+		{"Надеть рюкзак", "вы надели: рюкзак"},
+		{"подобрать красный ключ", "предмет добавлен в инвентарь: красный ключ"},
+		{"использовать \"красный ключ\" старая дверь", "дверь открыта"},
+		{"применить красный ключ старая дверь", "дверь открыта"},
+		{"применить синий ключ старая дверь", "нет предмета в инвентаре - синий"},
+		{"взять \"\"", "использование: взять <предмет>"},
+	}
+	checkSteps(t, w, defaultPlayer, steps)
+}
+
+func TestHelp(t *testing.T) {
+	help := helpText()
+	for _, v := range verbs {
+		if !strings.Contains(help, v.usage()+" - "+v.help) {
+			t.Error("no help for", v.name)
+		}
+	}
+	if !strings.Contains(help, "(посмотреть, оглядеться)") {
+		t.Error("no synonyms in help:", help)
+	}
+}
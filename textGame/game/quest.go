@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// questCondition - одно условие задачи, заполняется одно из полей
+type questCondition struct {
+	Wear  string `json:"wear"`
+	Have  string `json:"have"`
+	Reach string `json:"reach"`
+}
+
+func (c questCondition) check(p *Player) bool {
+	switch {
+	case c.Wear != "":
+		return hasThing(p.FitOn, c.Wear)
+	case c.Have != "":
+		return hasThing(p.Inventory, c.Have)
+	case c.Reach != "":
+		return p.Place != nil && p.Place.Name == c.Reach
+	}
+	return false
+}
+
+// Quest - задача игрока, выполняется когда все условия выполнены одновременно
+// задача без условий выполняется только правилом completeTask
+type Quest struct {
+	Name       string           `json:"name"`
+	Conditions []questCondition `json:"conditions"`
+	DoneText   string           `json:"doneText"`
+	Done       bool             `json:"done"`
+}
+
+func hasThing(things []*Thing, name string) bool {
+	for _, t := range things {
+		if t.getName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *Quest) progress(p *Player) int {
+	n := 0
+	for _, c := range q.Conditions {
+		if c.check(p) {
+			n++
+		}
+	}
+	return n
+}
+
+func (p *Player) addQuest(q Quest) {
+	p.Tasks = append(p.Tasks, &q)
+}
+
+func (p *Player) quest(name string) *Quest {
+	for _, q := range p.Tasks {
+		if q.Name == name {
+			return q
+		}
+	}
+	return nil
+}
+
+// completeQuest отмечает задачу выполненной и возвращает сообщение о выполнении
+func (p *Player) completeQuest(q *Quest) string {
+	q.Done = true
+	return q.DoneText
+}
+
+// updateQuests проверяет условия активных задач после хода игрока
+func (p *Player) updateQuests() []string {
+	messages := make([]string, 0)
+	for _, q := range p.Tasks {
+		if q.Done || len(q.Conditions) == 0 || q.progress(p) != len(q.Conditions) {
+			continue
+		}
+		if msg := p.completeQuest(q); msg != "" {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// tasksInfo - текст активных задач для осмотра комнаты
+func (p *Player) tasksInfo() string {
+	active := make([]string, 0, len(p.Tasks))
+	for _, q := range p.Tasks {
+		if !q.Done {
+			active = append(active, q.Name)
+		}
+	}
+	if len(active) == 0 {
+		return ""
+	}
+	return "надо " + strings.Join(active, " и ") + ", "
+}
+
+func (p *Player) listQuests() string {
+	active := make([]string, 0, len(p.Tasks))
+	done := make([]string, 0, len(p.Tasks))
+	for _, q := range p.Tasks {
+		if q.Done {
+			done = append(done, q.Name)
+			continue
+		}
+		task := q.Name
+		if len(q.Conditions) != 0 {
+			task += " " + strconv.Itoa(q.progress(p)) + "/" + strconv.Itoa(len(q.Conditions))
+		}
+		active = append(active, task)
+	}
+	if len(active) == 0 {
+		active = append(active, "нет")
+	}
+	if len(done) == 0 {
+		done = append(done, "нет")
+	}
+	return fmt.Sprintf("активные задачи: %s. выполненные задачи: %s",
+		strings.Join(active, ", "), strings.Join(done, ", "))
+}
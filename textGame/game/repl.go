@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	prompt        = "> "
+	historyCmd    = "история"
+	scriptCaseSep = "---"
+)
+
+// repl - построчный ввод команд одного игрока
+type repl struct {
+	world   *World
+	player  string
+	history []string
+}
+
+func (r *repl) run(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	fmt.Fprint(out, prompt)
+	for scanner.Scan() {
+		command := strings.TrimSpace(scanner.Text())
+		switch command {
+		case "":
+		case historyCmd:
+			for i, h := range r.history {
+				fmt.Fprintln(out, strconv.Itoa(i+1)+" "+h)
+			}
+		default:
+			r.history = append(r.history, command)
+			fmt.Fprintln(out, r.world.handleCommand(r.player, command))
+		}
+		fmt.Fprint(out, prompt)
+	}
+	fmt.Fprintln(out)
+	return scanner.Err()
+}
+
+// scriptError - ответ игры не совпал с ожидаемым в сценарии
+type scriptError struct {
+	line     int
+	command  string
+	answer   string
+	expected string
+}
+
+func (e scriptError) Error() string {
+	return fmt.Sprintf("line %d\n\tcmd: %s\n\tresult:   %s\n\texpected: %s",
+		e.line, e.command, e.answer, e.expected)
+}
+
+/*
+runScript проигрывает сценарий из текстового файла, по аналогии с game0cases:
+
+	команда<TAB>ожидаемый ответ
+
+строки с # - комментарии, строка --- начинает новый кейс с заново созданным миром.
+если ожидаемого ответа нет - команда просто выполняется
+*/
+func runScript(in io.Reader, newGame func() (*World, error), player string) ([]scriptError, error) {
+	w, err := newGame()
+	if err != nil {
+		return nil, err
+	}
+	fails := make([]scriptError, 0)
+	scanner := bufio.NewScanner(in)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if text == scriptCaseSep {
+			if w, err = newGame(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		command, expected, check := strings.Cut(text, "\t")
+		command = strings.TrimSpace(command)
+		answer := w.handleCommand(player, command)
+		if check && answer != strings.TrimSpace(expected) {
+			fails = append(fails, scriptError{line, command, answer, strings.TrimSpace(expected)})
+		}
+	}
+	return fails, scanner.Err()
+}
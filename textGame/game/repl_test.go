@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func defaultGame() (*World, error) {
+	return newWorldFromFile("")
+}
+
+func TestScript(t *testing.T) {
+	scripts, err := filepath.Glob("testdata/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, script := range scripts {
+		file, err := os.Open(script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fails, err := runScript(file, defaultGame, defaultPlayer)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, fail := range fails {
+			t.Error(script, fail)
+		}
+	}
+}
+
+func TestScriptMismatch(t *testing.T) {
+	script := "осмотреться\tне то\nидти коридор\n"
+	fails, err := runScript(strings.NewReader(script), defaultGame, defaultPlayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fails) != 1 || fails[0].line != 1 {
+		t.Errorf("expected one fail on line 1, got %v", fails)
+	}
+}
+
+func TestREPL(t *testing.T) {
+	w, err := defaultGame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &repl{world: w, player: defaultPlayer}
+	out := &bytes.Buffer{}
+	in := strings.NewReader("идти коридор\n\nзавтракать\nистория\n")
+	if err := r.run(in, out); err != nil {
+		t.Fatal(err)
+	}
+	expected := "> ничего интересного. можно пройти - кухня, комната, улица\n" +
+		"> > неизвестная команда\n" +
+		"> 1 идти коридор\n2 завтракать\n> \n"
+	if out.String() != expected {
+		t.Errorf("result:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+)
+
+// действия, которые может вызвать применение предмета
+const (
+	effectOpenDoor     = "openDoor"
+	effectCloseDoor    = "closeDoor"
+	effectSpawn        = "spawn"
+	effectDescribe     = "describe"
+	effectCompleteTask = "completeTask"
+	effectRemove       = "remove"
+)
+
+// ruleConfig - "предмет item, примененный к target в комнате room, дает effects"
+// пустой room - правило работает в любой комнате, где есть target
+type ruleConfig struct {
+	Item    string         `json:"item"`
+	Target  string         `json:"target"`
+	Room    string         `json:"room"`
+	Effects []effectConfig `json:"effects"`
+	Answer  string         `json:"answer"`
+}
+
+// effectConfig - одно действие правила
+// door по умолчанию - target правила, room по умолчанию - комната игрока
+// unique - spawn не кладет предмет, если такой уже есть в комнате
+type effectConfig struct {
+	Action    string `json:"action"`
+	Door      string `json:"door"`
+	Room      string `json:"room"`
+	Furniture string `json:"furniture"`
+	Thing     string `json:"thing"`
+	Info      string `json:"info"`
+	InfoMoved string `json:"infoMoved"`
+	Task      string `json:"task"`
+	Unique    bool   `json:"unique"`
+}
+
+func (rc *ruleConfig) check(rooms map[string]*Room, doors map[string]*Door) error {
+	if rc.Room != "" {
+		if _, ok := rooms[rc.Room]; !ok {
+			return fmt.Errorf("rule %s->%s: unknown room %q", rc.Item, rc.Target, rc.Room)
+		}
+	}
+	for _, ec := range rc.Effects {
+		if err := checkEffect(ec, rc.Target, rooms, doors); err != nil {
+			return fmt.Errorf("rule %s->%s: %w", rc.Item, rc.Target, err)
+		}
+	}
+	return nil
+}
+
+func checkEffect(ec effectConfig, target string, rooms map[string]*Room, doors map[string]*Door) error {
+	switch ec.Action {
+	case effectOpenDoor, effectCloseDoor:
+		door := ec.Door
+		if door == "" {
+			door = target
+		}
+		if _, ok := doors[door]; !ok {
+			return fmt.Errorf("unknown door %q", door)
+		}
+	case effectSpawn, effectDescribe, effectCompleteTask, effectRemove:
+	default:
+		return fmt.Errorf("unknown action %q", ec.Action)
+	}
+	if ec.Room != "" {
+		if _, ok := rooms[ec.Room]; !ok {
+			return fmt.Errorf("unknown room %q", ec.Room)
+		}
+	}
+	return nil
+}
+
+// hasTarget - есть ли в комнате дверь, мебель или предмет с таким именем
+func (r *Room) hasTarget(name string) bool {
+	for _, door := range r.Doors {
+		if door.Name == name {
+			return true
+		}
+	}
+	for _, f := range r.FurnitureInIt {
+		if f.getName() == name {
+			return true
+		}
+	}
+	return r.hasThing(name)
+}
+
+// hasThing - лежит ли в комнате предмет с таким именем
+func (r *Room) hasThing(name string) bool {
+	for _, f := range r.FurnitureInIt {
+		for _, t := range f.ThingsOnIt {
+			if t.getName() == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (w *World) findDoor(from *Room, name string) *Door {
+	if from != nil {
+		for _, door := range from.Doors {
+			if door.Name == name {
+				return door
+			}
+		}
+	}
+	for _, room := range w.Rooms {
+		for _, door := range room.Doors {
+			if door.Name == name {
+				return door
+			}
+		}
+	}
+	return nil
+}
+
+// applyEffect выполняет действие в комнате room, p может быть nil для событий мира
+func (w *World) applyEffect(p *Player, room *Room, target string, ec effectConfig) string {
+	if ec.Room != "" {
+		room = w.Rooms[ec.Room]
+	}
+	switch ec.Action {
+	case effectOpenDoor, effectCloseDoor:
+		name := ec.Door
+		if name == "" {
+			name = target
+		}
+		if door := w.findDoor(room, name); door != nil {
+			door.Status = ec.Action == effectOpenDoor
+		}
+		return ""
+	}
+	if room == nil {
+		return ""
+	}
+	switch ec.Action {
+	case effectSpawn:
+		if ec.Unique && room.hasThing(ec.Thing) {
+			return ""
+		}
+		for i := range room.FurnitureInIt {
+			if room.FurnitureInIt[i].getName() == ec.Furniture {
+				room.FurnitureInIt[i].addThing(w.newThing(ec.Thing))
+				return ""
+			}
+		}
+		room.addFurniture(w.newFurniture(furnitureConfig{Name: ec.Furniture, Things: []string{ec.Thing}}))
+	case effectDescribe:
+		room.Info = ec.Info
+		room.InfoMoved = ec.InfoMoved
+	case effectRemove:
+		if ec.Furniture == "" {
+			room.takeThing(ec.Thing)
+		} else if f := room.findFurniture(ec.Furniture); f != nil {
+			f.ThingsOnIt, _ = removeThing(f.ThingsOnIt, ec.Thing)
+		}
+	case effectCompleteTask:
+		if p == nil {
+			return ""
+		}
+		if q := p.quest(ec.Task); q != nil && !q.Done {
+			return p.completeQuest(q)
+		}
+	}
+	return ""
+}
+
+func (w *World) useThing(p *Player, thing string, target string) string {
+	able := false
+	for i := range p.Inventory {
+		if p.Inventory[i].getName() == thing {
+			able = true
+		}
+	}
+	if !able {
+		return "нет предмета в инвентаре - " + thing
+	}
+
+	for i := range w.rules {
+		rc := &w.rules[i]
+		if rc.Item != thing || rc.Target != target {
+			continue
+		}
+		if rc.Room != "" && rc.Room != p.Place.Name {
+			continue
+		}
+		if !p.Place.hasTarget(target) {
+			continue
+		}
+		answer := rc.Answer
+		for _, ec := range rc.Effects {
+			if msg := w.applyEffect(p, p.Place, rc.Target, ec); msg != "" {
+				answer += ". " + msg
+			}
+		}
+		return answer
+	}
+	return "не к чему применить"
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+)
+
+const rulesWorld = `{
+	"start": "кухня",
+	"quests": [{"name": "покормить кота", "doneText": "задача выполнена"}],
+	"items": [{"name": "рюкзак", "capacity": 5}],
+	"rooms": [
+		{"name": "кухня", "showTasks": true, "info": "ты на кухне,", "infoMoved": "кухня", "furniture": [
+			{"name": "стол", "things": ["рюкзак", "ключ", "корм", "отмычка"]},
+			{"name": "миска"}
+		]},
+		{"name": "кладовка", "infoMoved": "темно"}
+	],
+	"doors": [{"name": "кладовка", "rooms": ["кухня", "кладовка"]}],
+	"links": [{"door": "кладовка", "from": "кухня", "to": ["кладовка"]}],
+	"rules": [
+		{"item": "ключ", "target": "кладовка", "room": "кухня", "effects": [{"action": "openDoor"}], "answer": "дверь открыта"},
+		{"item": "отмычка", "target": "кладовка", "room": "кладовка", "effects": [{"action": "closeDoor"}], "answer": "дверь закрыта"},
+		{"item": "корм", "target": "миска", "answer": "кот доволен", "effects": [
+			{"action": "spawn", "furniture": "стол", "thing": "шерсть"},
+			{"action": "describe", "info": "ты на сытой кухне,", "infoMoved": "кухня"},
+			{"action": "completeTask", "task": "покормить кота"}
+		]}
+	]
+}`
+
+func TestRules(t *testing.T) {
+	cfg, err := parseWorld([]byte(rulesWorld))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWorld(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []gameStep{
+		{"надеть рюкзак", "вы надели: рюкзак"},
+		{"взять ключ", "предмет добавлен в инвентарь: ключ"},
+		{"взять корм", "предмет добавлен в инвентарь: корм"},
+		{"взять отмычка", "предмет добавлен в инвентарь: отмычка"},
+		{"идти кладовка", "дверь закрыта"},
+		{"применить корм кладовка", "не к чему применить"},
+		{"применить отмычка кладовка", "не к чему применить"},
+		{"применить ключ миска", "не к чему применить"},
+		{"применить ключ кладовка", "дверь открыта"},
+		{"идти кладовка", "темно. можно пройти - кухня"},
+		{"применить ключ кладовка", "не к чему применить"},
+		{"применить отмычка кладовка", "дверь закрыта"},
+		{"идти кухня", "дверь закрыта"},
+		{"применить корм миска", "не к чему применить"},
+		{"применить телефон миска", "нет предмета в инвентаре - телефон"},
+	}
+	checkSteps(t, w, defaultPlayer, steps)
+
+	p := w.Players[defaultPlayer]
+	p.setPlace(w.Rooms["кухня"])
+	if answer := w.handleCommand(defaultPlayer, "осмотреться"); answer != "пустая комната, надо покормить кота. можно пройти - кладовка" {
+		t.Error("unexpected answer:", answer)
+	}
+	if answer := w.handleCommand(defaultPlayer, "применить корм миска"); answer != "кот доволен. задача выполнена" {
+		t.Error("unexpected answer:", answer)
+	}
+	if answer := w.handleCommand(defaultPlayer, "осмотреться"); answer != "ты на сытой кухне, на столе: шерсть. можно пройти - кладовка" {
+		t.Error("unexpected answer:", answer)
+	}
+	if !p.quest("покормить кота").Done {
+		t.Error("task is not completed")
+	}
+}
+
+func TestBadRules(t *testing.T) {
+	cases := []string{
+		`{"start": "a", "rooms": [{"name": "a"}], "rules": [{"item": "x", "target": "y", "room": "b"}]}`,
+		`{"start": "a", "rooms": [{"name": "a"}], "rules": [{"item": "x", "target": "y", "effects": [{"action": "openDoor"}]}]}`,
+		`{"start": "a", "rooms": [{"name": "a"}], "rules": [{"item": "x", "target": "y", "effects": [{"action": "fly"}]}]}`,
+		`{"start": "a", "rooms": [{"name": "a"}], "rules": [{"item": "x", "target": "y", "effects": [{"action": "spawn", "room": "b"}]}]}`,
+	}
+	for _, data := range cases {
+		cfg, err := parseWorld([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = newWorld(cfg); err == nil {
+			t.Error("expected error for", data)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const defaultSaveDir = "saves"
+
+// worldState - полный снимок мира и игроков для сохранения на диск
+// связи между комнатами хранятся по именам комнат и номерам дверей
+type worldState struct {
+	Start   string        `json:"start"`
+	Quests  []Quest       `json:"quests"`
+	Rooms   []roomState   `json:"rooms"`
+	Doors   []doorState   `json:"doors"`
+	Players []playerState `json:"players"`
+	NPCs    []npcState    `json:"npcs"`
+	Events  []eventState  `json:"events"`
+	Turn    int           `json:"turn"`
+}
+
+// eventState - когда событие по часам сработало в последний раз, от создания мира
+type eventState struct {
+	Last time.Duration `json:"last"`
+}
+
+type npcState struct {
+	Name  string `json:"name"`
+	Place string `json:"place"`
+	Step  int    `json:"step"`
+	Line  int    `json:"line"`
+}
+
+type roomState struct {
+	Name        string            `json:"name"`
+	Info        string            `json:"info"`
+	InfoMoved   string            `json:"infoMoved"`
+	ShowTasks   bool              `json:"showTasks"`
+	Forms       nounForms         `json:"forms"`
+	Preposition string            `json:"preposition"`
+	NextRooms   []string          `json:"nextRooms"`
+	Doors       []int             `json:"doors"`
+	Furniture   []furnitureConfig `json:"furniture"`
+}
+
+type doorState struct {
+	Name  string    `json:"name"`
+	Open  bool      `json:"open"`
+	Rooms [2]string `json:"rooms"`
+}
+
+type playerState struct {
+	Name      string   `json:"name"`
+	Place     string   `json:"place"`
+	Quests    []Quest  `json:"quests"`
+	Inventory []string `json:"inventory"`
+	FitOn     []string `json:"fitOn"`
+}
+
+func thingNames(things []*Thing) []string {
+	names := make([]string, 0, len(things))
+	for _, t := range things {
+		names = append(names, t.getName())
+	}
+	return names
+}
+
+func roomName(r *Room) string {
+	if r == nil {
+		return ""
+	}
+	return r.Name
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (w *World) snapshot() *worldState {
+	state := &worldState{Start: roomName(w.start), Quests: w.quests, Turn: w.turn}
+	doorIdx := make(map[*Door]int)
+	for _, name := range sortedKeys(w.Rooms) {
+		room := w.Rooms[name]
+		rs := roomState{Name: room.Name, Info: room.Info, InfoMoved: room.InfoMoved, ShowTasks: room.ShowTasks, Forms: room.Forms, Preposition: room.Preposition}
+		for _, next := range room.NextRooms {
+			rs.NextRooms = append(rs.NextRooms, next.Name)
+		}
+		for _, door := range room.Doors {
+			idx, ok := doorIdx[door]
+			if !ok {
+				idx = len(state.Doors)
+				doorIdx[door] = idx
+				state.Doors = append(state.Doors, doorState{
+					Name:  door.Name,
+					Open:  door.Status,
+					Rooms: [2]string{roomName(door.RoomConnect[0]), roomName(door.RoomConnect[1])},
+				})
+			}
+			rs.Doors = append(rs.Doors, idx)
+		}
+		for _, f := range room.FurnitureInIt {
+			rs.Furniture = append(rs.Furniture, furnitureConfig{
+				Name:        f.Name,
+				Forms:       f.Forms,
+				Preposition: f.Preposition,
+				Things:      thingNames(f.ThingsOnIt),
+			})
+		}
+		state.Rooms = append(state.Rooms, rs)
+	}
+	for _, name := range sortedKeys(w.Players) {
+		p := w.Players[name]
+		quests := make([]Quest, 0, len(p.Tasks))
+		for _, q := range p.Tasks {
+			quests = append(quests, *q)
+		}
+		state.Players = append(state.Players, playerState{
+			Name:      p.Name,
+			Place:     roomName(p.Place),
+			Quests:    quests,
+			Inventory: thingNames(p.Inventory),
+			FitOn:     thingNames(p.FitOn),
+		})
+	}
+	for _, npc := range w.npcs {
+		state.NPCs = append(state.NPCs, npcState{npc.Name, roomName(npc.Place), npc.step, npc.line})
+	}
+	for _, ev := range w.events {
+		state.Events = append(state.Events, eventState{ev.last.Sub(w.started)})
+	}
+	return state
+}
+
+// restore заменяет содержимое мира сохраненным состоянием
+func (w *World) restore(state *worldState) error {
+	rooms := make(map[string]*Room, len(state.Rooms))
+	for _, rs := range state.Rooms {
+		room := &Room{Name: rs.Name, Info: rs.Info, InfoMoved: rs.InfoMoved, ShowTasks: rs.ShowTasks, Forms: rs.Forms, Preposition: rs.Preposition}
+		for _, fc := range rs.Furniture {
+			room.addFurniture(w.newFurniture(fc))
+		}
+		rooms[rs.Name] = room
+	}
+	getRoom := func(name string) (*Room, error) {
+		if name == "" {
+			return nil, nil
+		}
+		room, ok := rooms[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown room %q", name)
+		}
+		return room, nil
+	}
+
+	doors := make([]*Door, 0, len(state.Doors))
+	for _, ds := range state.Doors {
+		door := &Door{Name: ds.Name, Status: ds.Open}
+		for i, name := range ds.Rooms {
+			room, err := getRoom(name)
+			if err != nil {
+				return err
+			}
+			door.RoomConnect[i] = room
+		}
+		doors = append(doors, door)
+	}
+
+	for _, rs := range state.Rooms {
+		room := rooms[rs.Name]
+		for _, name := range rs.NextRooms {
+			next, err := getRoom(name)
+			if err != nil {
+				return err
+			}
+			room.addRoom(next)
+		}
+		for _, idx := range rs.Doors {
+			if idx < 0 || idx >= len(doors) {
+				return fmt.Errorf("unknown door %d in room %q", idx, rs.Name)
+			}
+			room.Doors = append(room.Doors, doors[idx])
+		}
+	}
+
+	// у двери может не быть второй комнаты, а игрок и старт без комнаты не обходятся
+	start, err := getRoom(state.Start)
+	if err == nil && start == nil {
+		err = errors.New("no start room")
+	}
+	if err != nil {
+		return err
+	}
+	players := make(map[string]*Player, len(state.Players))
+	for _, ps := range state.Players {
+		place, err := getRoom(ps.Place)
+		if err == nil && place == nil {
+			err = fmt.Errorf("player %q is in no room", ps.Name)
+		}
+		if err != nil {
+			return err
+		}
+		p := &Player{
+			Name:         ps.Name,
+			Place:        place,
+			Inventory:    w.newThings(ps.Inventory),
+			FitOn:        w.newThings(ps.FitOn),
+			backpackRule: !w.capacityConfigured(),
+		}
+		for _, q := range ps.Quests {
+			p.addQuest(q)
+		}
+		players[ps.Name] = p
+	}
+
+	// маршруты персонажей ссылаются на старые комнаты
+	// новое состояние собирается отдельно, чтобы при ошибке мир остался прежним
+	npcs := make([]NPC, 0, len(w.npcs))
+	for _, npc := range w.npcs {
+		next := NPC{Name: npc.Name, Forms: npc.Forms, Every: npc.Every, Lines: npc.Lines}
+		for _, room := range npc.Route {
+			if rooms[room.Name] == nil {
+				return fmt.Errorf("npc %q: unknown room %q", npc.Name, room.Name)
+			}
+			next.Route = append(next.Route, rooms[room.Name])
+		}
+		next.Place = next.Route[0]
+		for _, ns := range state.NPCs {
+			if ns.Name != npc.Name {
+				continue
+			}
+			if next.Place, err = getRoom(ns.Place); err != nil || next.Place == nil {
+				return fmt.Errorf("npc %q: unknown room %q", npc.Name, ns.Place)
+			}
+			next.step, next.line = ns.Step, ns.Line
+		}
+		npcs = append(npcs, next)
+	}
+
+	// в старых сохранениях событий нет, тогда часы событий не трогаем
+	if len(state.Events) != 0 && len(state.Events) != len(w.events) {
+		return fmt.Errorf("save has %d events, world has %d", len(state.Events), len(w.events))
+	}
+
+	for i, npc := range w.npcs {
+		*npc = npcs[i]
+	}
+	for i, es := range state.Events {
+		w.events[i].last = w.started.Add(es.Last)
+	}
+	w.Rooms = rooms
+	w.Players = players
+	w.start = start
+	w.quests = state.Quests
+	w.turn = state.Turn
+	return nil
+}
+
+func (w *World) savePath(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("bad save name %q", name)
+	}
+	dir := w.saveDir
+	if dir == "" {
+		dir = defaultSaveDir
+	}
+	return filepath.Join(dir, name+".json"), nil
+}
+
+func (w *World) save(name string) error {
+	path, err := w.savePath(name)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(w.snapshot(), "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+func (w *World) load(name string) error {
+	path, err := w.savePath(name)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	state := &worldState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return fmt.Errorf("bad save file: %w", err)
+	}
+	return w.restore(state)
+}
+
+func (w *World) saveGame(name string) string {
+	if w.noSaves {
+		return "сохранения отключены"
+	}
+	if err := w.save(name); err != nil {
+		return "не удалось сохранить игру"
+	}
+	return "игра сохранена: " + name
+}
+
+func (w *World) loadGame(name string) string {
+	if w.noSaves {
+		return "сохранения отключены"
+	}
+	err := w.load(name)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return "нет такого сохранения"
+	case err != nil:
+		return "не удалось загрузить игру"
+	}
+	return "игра загружена: " + name
+}
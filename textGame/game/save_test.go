@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	w, err := newWorldFromFile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.saveDir = t.TempDir()
+	steps := []gameStep{
+		{"идти коридор", "ничего интересного. можно пройти - кухня, комната, улица"},
+		{"идти комната", "ты в своей комнате. можно пройти - коридор"},
+		{"надеть рюкзак", "вы надели: рюкзак"},
+		{"взять ключи", "предмет добавлен в инвентарь: ключи"},
+		{"сохранить s1", "игра сохранена: s1"},
+		{"идти коридор", "ничего интересного. можно пройти - кухня, комната, улица"},
+		{"применить ключи дверь", "дверь открыта"},
+		{"идти улица", "на улице весна. можно пройти - домой"},
+		{"load s1", "игра загружена: s1"},
+		{"осмотреться", "на столе: конспекты. можно пройти - коридор"},
+		{"взять конспекты", "предмет добавлен в инвентарь: конспекты"},
+		{"идти коридор", "ничего интересного. можно пройти - кухня, комната, улица"},
+		{"идти кухня", "кухня, ничего интересного. можно пройти - коридор"},
+		{"осмотреться", "ты находишься на кухне, на столе: чай, надо идти в универ. можно пройти - коридор"},
+		{"идти коридор", "ничего интересного. можно пройти - кухня, комната, улица"},
+		{"идти улица", "дверь закрыта"},
+		{"применить ключи дверь", "дверь открыта"},
+		{"идти улица", "на улице весна. можно пройти - домой"},
+		{"загрузить s2", "нет такого сохранения"},
+		{"сохранить ../s1", "не удалось сохранить игру"},
+		{"сохранить", "использование: сохранить <имя>"},
+	}
+	checkSteps(t, w, defaultPlayer, steps)
+
+	if err := w.load("s1"); err != nil {
+		t.Fatal(err)
+	}
+	hall, street := w.Rooms["коридор"], w.Rooms["улица"]
+	if hall.Doors[2] != street.Doors[0] || street.Doors[0].RoomConnect != [2]*Room{hall, street} {
+		t.Error("doors are not shared between rooms after load")
+	}
+	if hall.NextRooms[0] != w.Rooms["кухня"] || w.Players[defaultPlayer].Place != w.Rooms["комната"] {
+		t.Error("room links are broken after load")
+	}
+}
+
+func TestLoadBadRooms(t *testing.T) {
+	w, err := newWorldFromFile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.saveDir = t.TempDir()
+	checkSteps(t, w, defaultPlayer, []gameStep{{"сохранить s1", "игра сохранена: s1"}})
+	data, err := os.ReadFile(filepath.Join(w.saveDir, "s1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// игрок без комнаты упал бы на следующей команде, такое сохранение не загружается
+	for i, bad := range []string{
+		strings.Replace(string(data), `"place": "кухня"`, `"place": ""`, 1),
+		strings.Replace(string(data), `"place": "кухня"`, `"place": "чердак"`, 1),
+		strings.Replace(string(data), `"start": "кухня"`, `"start": ""`, 1),
+	} {
+		if bad == string(data) {
+			t.Fatal("save format changed:", i)
+		}
+		if err := os.WriteFile(filepath.Join(w.saveDir, "bad.json"), []byte(bad), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		checkSteps(t, w, defaultPlayer, []gameStep{
+			{"загрузить bad", "не удалось загрузить игру"},
+			{"осмотреться", "ты находишься на кухне, на столе: чай, надо собрать рюкзак и идти в универ. можно пройти - коридор"},
+		})
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSessionTTL  = 30 * time.Minute
+	defaultMaxSessions = 100
+
+	readTimeout  = 10 * time.Second
+	writeTimeout = 10 * time.Second
+	idleTimeout  = time.Minute
+
+	maxCommandBody = 64 << 10
+)
+
+// session - отдельный мир со своими игроками, команды выполняются по одной
+type session struct {
+	mu       sync.Mutex
+	world    *World
+	lastUsed time.Time
+	clients  map[*wsClient]struct{}
+}
+
+type wsClient struct {
+	conn   *wsConn
+	player string
+}
+
+// wsMessage - то, что сервер шлет в websocket: ответ на команду, команда другого игрока в комнате
+// или новость - сообщение события мира или персонажа, которое иначе пришло бы с ответом на следующую команду
+type wsMessage struct {
+	Type    string `json:"type"`
+	Player  string `json:"player,omitempty"`
+	Command string `json:"command,omitempty"`
+	Answer  string `json:"answer,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type commandRequest struct {
+	Player  string `json:"player"`
+	Command string `json:"command"`
+}
+
+// gameServer - сессии с отдельными мирами
+// maxSessions ограничивает число живых сессий, origins - с каких страниц можно открыть websocket
+type gameServer struct {
+	mu          sync.Mutex
+	sessions    map[string]*session
+	ttl         time.Duration
+	maxSessions int
+	origins     []string
+	newGame     func() (*World, error)
+	now         func() time.Time
+}
+
+func newGameServer(newGame func() (*World, error), ttl time.Duration) *gameServer {
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+	return &gameServer{
+		sessions:    make(map[string]*session),
+		ttl:         ttl,
+		maxSessions: defaultMaxSessions,
+		newGame:     newGame,
+		now:         time.Now,
+	}
+}
+
+func (gs *gameServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/session", gs.createSession)
+	mux.HandleFunc("/session/", gs.sessionHandler)
+	return mux
+}
+
+// sessionHandler разбирает /session/{id}/command, /session/{id}/ws и /session/{id}/journal
+func (gs *gameServer) sessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/session/"), "/")
+	switch {
+	case ok && action == "command" && r.Method == http.MethodPost:
+		gs.command(w, r, id)
+	case ok && action == "ws" && r.Method == http.MethodGet:
+		gs.websocket(w, r, id)
+	case ok && action == "journal" && r.Method == http.MethodGet:
+		gs.journal(w, id)
+	default:
+		writeJSONError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func newSessionID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("write response: %v", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func (gs *gameServer) createSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if gs.full() {
+		writeJSONError(w, http.StatusServiceUnavailable, "too many sessions")
+		return
+	}
+	world, err := gs.newGame()
+	if err != nil {
+		log.Printf("new game: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "can't create world")
+		return
+	}
+	// все сессии делят одну папку, так что сохраняться они не могут
+	world.noSaves = true
+	id, err := newSessionID()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "can't create session")
+		return
+	}
+	gs.mu.Lock()
+	if len(gs.sessions) >= gs.maxSessions {
+		gs.mu.Unlock()
+		writeJSONError(w, http.StatusServiceUnavailable, "too many sessions")
+		return
+	}
+	gs.sessions[id] = &session{world: world, lastUsed: gs.now(), clients: make(map[*wsClient]struct{})}
+	gs.mu.Unlock()
+	writeJSON(w, http.StatusCreated, map[string]string{"id": id})
+}
+
+func (gs *gameServer) full() bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	return len(gs.sessions) >= gs.maxSessions
+}
+
+// checkOrigin пускает клиентов без Origin, страницы с того же хоста и из списка origins
+func (gs *gameServer) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range gs.origins {
+		if strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// session возвращает сессию и продлевает ей жизнь
+func (gs *gameServer) session(id string) *session {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	s, ok := gs.sessions[id]
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	s.lastUsed = gs.now()
+	s.mu.Unlock()
+	return s
+}
+
+func (gs *gameServer) command(w http.ResponseWriter, r *http.Request, id string) {
+	s := gs.session(id)
+	if s == nil {
+		writeJSONError(w, http.StatusNotFound, "no such session")
+		return
+	}
+	req := commandRequest{}
+	body := http.MaxBytesReader(w, r.Body, maxCommandBody)
+	if err := json.NewDecoder(body).Decode(&req); err != nil || req.Command == "" {
+		writeJSONError(w, http.StatusBadRequest, "bad command")
+		return
+	}
+	if req.Player == "" {
+		req.Player = defaultPlayer
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"answer": s.run(req.Player, req.Command)})
+}
+
+// journal отдает журнал сессии в формате json lines, его можно проиграть через -replay
+func (gs *gameServer) journal(w http.ResponseWriter, id string) {
+	s := gs.session(id)
+	if s == nil {
+		writeJSONError(w, http.StatusNotFound, "no such session")
+		return
+	}
+	s.mu.Lock()
+	journal := s.world.exportJournal()
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	if err := writeJournal(w, journal); err != nil {
+		log.Printf("write journal: %v", err)
+	}
+}
+
+func (gs *gameServer) websocket(w http.ResponseWriter, r *http.Request, id string) {
+	s := gs.session(id)
+	if s == nil {
+		writeJSONError(w, http.StatusNotFound, "no such session")
+		return
+	}
+	if !gs.checkOrigin(r) {
+		writeJSONError(w, http.StatusForbidden, "origin not allowed")
+		return
+	}
+	player := r.URL.Query().Get("player")
+	if player == "" {
+		player = defaultPlayer
+	}
+	conn, err := upgradeWS(w, r)
+	if err != nil {
+		return
+	}
+	client := &wsClient{conn: conn, player: player}
+	s.mu.Lock()
+	s.clients[client] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, client)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		command, err := conn.readMessage()
+		if err != nil {
+			return
+		}
+		answer := s.run(player, command)
+		gs.touch(s)
+		if err := client.send(wsMessage{Type: "answer", Command: command, Answer: answer}); err != nil {
+			return
+		}
+	}
+}
+
+func (gs *gameServer) touch(s *session) {
+	s.mu.Lock()
+	s.lastUsed = gs.now()
+	s.mu.Unlock()
+}
+
+func (c *wsClient) send(msg wsMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.conn.writeMessage(data)
+}
+
+// run выполняет команду и рассылает событие подключенным игрокам,
+// которые были в комнате игрока до команды или оказались в ней после,
+// а остальным подключенным - накопившиеся у них новости
+func (s *session) run(player, command string) string {
+	s.mu.Lock()
+	var before *Room
+	if p, ok := s.world.Players[player]; ok {
+		before = p.Place
+	}
+	answer := s.world.handleCommand(player, command)
+	var after *Room
+	if p, ok := s.world.Players[player]; ok {
+		after = p.Place
+	}
+	watchers := make([]*wsClient, 0)
+	readers := make([]*wsClient, 0)
+	news := make(map[string][]string)
+	for c := range s.clients {
+		p, ok := s.world.Players[c.player]
+		if c.player == player || !ok {
+			continue
+		}
+		if p.Place == before || p.Place == after {
+			watchers = append(watchers, c)
+		}
+		// у одного игрока может быть несколько подключений, новости достаются всем
+		if _, ok := news[c.player]; !ok {
+			news[c.player] = p.takeNews()
+		}
+		if len(news[c.player]) != 0 {
+			readers = append(readers, c)
+		}
+	}
+	s.mu.Unlock()
+
+	event := wsMessage{Type: "event", Player: player, Command: command}
+	for _, c := range watchers {
+		if err := c.send(event); err != nil {
+			c.conn.Close()
+		}
+	}
+	for _, c := range readers {
+		for _, msg := range news[c.player] {
+			if err := c.send(wsMessage{Type: "news", Message: msg}); err != nil {
+				c.conn.Close()
+				break
+			}
+		}
+	}
+	return answer
+}
+
+// expire удаляет сессии, в которых давно ничего не происходило
+func (gs *gameServer) expire() {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	now := gs.now()
+	for id, s := range gs.sessions {
+		s.mu.Lock()
+		if now.Sub(s.lastUsed) > gs.ttl {
+			for c := range s.clients {
+				c.conn.Close()
+			}
+			delete(gs.sessions, id)
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (gs *gameServer) expireLoop(done <-chan struct{}) {
+	ticker := time.NewTicker(gs.ttl / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			gs.expire()
+		case <-done:
+			return
+		}
+	}
+}
+
+func serveHTTP(addr string, gs *gameServer) error {
+	done := make(chan struct{})
+	defer close(done)
+	go gs.expireLoop(done)
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           gs.routes(),
+		ReadHeaderTimeout: readTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	log.Printf("textGame server listening on %s", addr)
+	return srv.ListenAndServe()
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postJSON(t *testing.T, url string, body string, out interface{}) int {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body)) //nolint:gosec
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+type testWSClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialWS(t *testing.T, addr, path string) *testWSClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	req := "GET " + path + " HTTP/1.1\r\nHost: " + addr + "\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\nSec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("bad handshake: %v %v", resp.Status, resp.Header)
+	}
+	return &testWSClient{conn, r}
+}
+
+func (c *testWSClient) send(t *testing.T, text string) {
+	t.Helper()
+	if err := writeWSFrame(c.conn, wsOpText, []byte(text), []byte{1, 2, 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *testWSClient) read(t *testing.T) wsMessage {
+	t.Helper()
+	_ = c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, opcode, payload, err := readWSFrame(c.r, false)
+	if err != nil || opcode != wsOpText {
+		t.Fatalf("read frame: %v %v", opcode, err)
+	}
+	msg := wsMessage{}
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestServer(t *testing.T) {
+	gs := newGameServer(defaultGame, time.Minute)
+	ts := httptest.NewServer(gs.routes())
+	defer ts.Close()
+
+	created := map[string]string{}
+	if code := postJSON(t, ts.URL+"/session", "", &created); code != http.StatusCreated || created["id"] == "" {
+		t.Fatalf("create session: %d %v", code, created)
+	}
+	other := map[string]string{}
+	postJSON(t, ts.URL+"/session", "", &other)
+	base := ts.URL + "/session/" + created["id"]
+
+	answer := map[string]string{}
+	postJSON(t, base+"/command", `{"command": "идти коридор"}`, &answer)
+	if answer["answer"] != "ничего интересного. можно пройти - кухня, комната, улица" {
+		t.Error("unexpected answer:", answer)
+	}
+	// у другой сессии свой мир
+	postJSON(t, ts.URL+"/session/"+other["id"]+"/command", `{"command": "идти комната"}`, &answer)
+	if answer["answer"] != "нет пути в комната" {
+		t.Error("sessions share state:", answer)
+	}
+	if code := postJSON(t, ts.URL+"/session/nope/command", `{"command": "осмотреться"}`, nil); code != http.StatusNotFound {
+		t.Error("expected 404, got", code)
+	}
+	if code := postJSON(t, base+"/command", `{"player": "вася"}`, nil); code != http.StatusBadRequest {
+		t.Error("expected 400, got", code)
+	}
+
+	addr := strings.TrimPrefix(ts.URL, "http://")
+	vasya := dialWS(t, addr, "/session/"+created["id"]+"/ws?player=вася")
+	defer vasya.conn.Close()
+	petya := dialWS(t, addr, "/session/"+created["id"]+"/ws?player=петя")
+	defer petya.conn.Close()
+
+	vasya.send(t, "осмотреться")
+	if msg := vasya.read(t); msg.Type != "answer" || !strings.HasPrefix(msg.Answer, "ты находишься на кухне") {
+		t.Error("unexpected message:", msg)
+	}
+	petya.send(t, "осмотреться")
+	if msg := petya.read(t); msg.Type != "answer" || !strings.HasSuffix(msg.Answer, "кроме тебя здесь: вася") {
+		t.Error("unexpected message:", msg)
+	}
+	if msg := vasya.read(t); msg.Type != "event" || msg.Player != "петя" || msg.Command != "осмотреться" {
+		t.Error("unexpected event:", msg)
+	}
+
+	var buf bytes.Buffer
+	if err := writeWSFrame(&buf, wsOpClose, nil, []byte{1, 2, 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := petya.conn.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if _, opcode, _, err := readWSFrame(petya.r, false); err != nil || opcode != wsOpClose {
+		t.Error("expected close frame:", opcode, err)
+	}
+}
+
+func TestSessionExpire(t *testing.T) {
+	now := time.Now()
+	gs := newGameServer(defaultGame, time.Minute)
+	gs.now = func() time.Time { return now }
+	ts := httptest.NewServer(gs.routes())
+	defer ts.Close()
+
+	first, second := map[string]string{}, map[string]string{}
+	postJSON(t, ts.URL+"/session", "", &first)
+	now = now.Add(50 * time.Second)
+	postJSON(t, ts.URL+"/session", "", &second)
+	now = now.Add(20 * time.Second)
+	gs.expire()
+
+	if code := postJSON(t, ts.URL+"/session/"+first["id"]+"/command", `{"command": "осмотреться"}`, nil); code != http.StatusNotFound {
+		t.Error("idle session is not expired:", code)
+	}
+	if code := postJSON(t, ts.URL+"/session/"+second["id"]+"/command", `{"command": "осмотреться"}`, nil); code != http.StatusOK {
+		t.Error("active session is expired:", code)
+	}
+}
+
+func TestServerLimits(t *testing.T) {
+	gs := newGameServer(defaultGame, time.Minute)
+	gs.maxSessions = 1
+	ts := httptest.NewServer(gs.routes())
+	defer ts.Close()
+
+	created := map[string]string{}
+	postJSON(t, ts.URL+"/session", "", &created)
+	if code := postJSON(t, ts.URL+"/session", "", nil); code != http.StatusServiceUnavailable {
+		t.Error("expected 503, got", code)
+	}
+	base := ts.URL + "/session/" + created["id"]
+
+	answer := map[string]string{}
+	postJSON(t, base+"/command", `{"command": "сохранить игра"}`, &answer)
+	if answer["answer"] != "сохранения отключены" {
+		t.Error("unexpected answer:", answer)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, base+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", "http://evil.example")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Error("expected 403 for foreign origin, got", resp.StatusCode)
+	}
+
+	// кадры от клиента без маски отвергаются
+	client := dialWS(t, strings.TrimPrefix(ts.URL, "http://"), "/session/"+created["id"]+"/ws")
+	defer client.conn.Close()
+	if err := writeWSFrame(client.conn, wsOpText, []byte("осмотреться"), nil); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, _, err := readWSFrame(client.r, false); err == nil {
+		t.Error("unmasked frame is accepted")
+	}
+}
+
+func TestServerNews(t *testing.T) {
+	newGame := func() (*World, error) {
+		cfg, err := parseWorld([]byte(`{
+			"start": "кухня",
+			"rooms": [{"name": "кухня", "infoMoved": "кухня"}],
+			"events": [{"every": 2, "room": "кухня", "message": "часы пробили"}]
+		}`))
+		if err != nil {
+			return nil, err
+		}
+		return newWorld(cfg)
+	}
+	gs := newGameServer(newGame, time.Minute)
+	ts := httptest.NewServer(gs.routes())
+	defer ts.Close()
+
+	created := map[string]string{}
+	postJSON(t, ts.URL+"/session", "", &created)
+	base := ts.URL + "/session/" + created["id"]
+	vasya := dialWS(t, strings.TrimPrefix(ts.URL, "http://"), "/session/"+created["id"]+"/ws?player=вася")
+	defer vasya.conn.Close()
+	vasya.send(t, "осмотреться")
+	vasya.read(t)
+
+	// событие сработало на ходу пети, вася узнает о нем сразу, а не со своей следующей командой
+	for i := 0; i < 2; i++ {
+		postJSON(t, base+"/command", `{"player": "петя", "command": "осмотреться"}`, nil)
+		if msg := vasya.read(t); msg.Type != "event" || msg.Player != "петя" {
+			t.Error("unexpected event:", msg)
+		}
+	}
+	if msg := vasya.read(t); msg.Type != "news" || msg.Message != "часы пробили" {
+		t.Error("unexpected news:", msg)
+	}
+	vasya.send(t, "осмотреться")
+	if msg := vasya.read(t); msg.Type != "answer" || strings.Contains(msg.Answer, "часы пробили") {
+		t.Error("news is delivered twice:", msg)
+	}
+
+	big := `{"command": "` + strings.Repeat("а", maxCommandBody) + `"}`
+	if code := postJSON(t, base+"/command", big, nil); code != http.StatusBadRequest {
+		t.Error("expected 400 for a huge body, got", code)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// reachable - комнаты, в которые можно попасть из стартовой по NextRooms
+func (w *World) reachable() map[*Room]bool {
+	seen := map[*Room]bool{}
+	if w.start == nil {
+		return seen
+	}
+	queue := []*Room{w.start}
+	seen[w.start] = true
+	for len(queue) != 0 {
+		room := queue[0]
+		queue = queue[1:]
+		for _, next := range room.NextRooms {
+			if !seen[next] {
+				seen[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return seen
+}
+
+func linked(a, b *Room) bool {
+	for _, next := range a.NextRooms {
+		if next == b {
+			return true
+		}
+	}
+	return false
+}
+
+// obtainable - предметы, которые есть в мире или появляются по правилам и событиям
+func (w *World) obtainable() map[string]bool {
+	items := map[string]bool{}
+	for _, room := range w.Rooms {
+		for _, f := range room.FurnitureInIt {
+			for _, t := range f.ThingsOnIt {
+				items[t.getName()] = true
+			}
+		}
+	}
+	for _, rc := range w.rules {
+		for _, ec := range rc.Effects {
+			if ec.Action == effectSpawn {
+				items[ec.Thing] = true
+			}
+		}
+	}
+	for _, ev := range w.events {
+		for _, ec := range ev.Effects {
+			if ec.Action == effectSpawn {
+				items[ec.Thing] = true
+			}
+		}
+	}
+	return items
+}
+
+func (w *World) completedByRule(quest string) bool {
+	for _, rc := range w.rules {
+		for _, ec := range rc.Effects {
+			if ec.Action == effectCompleteTask && ec.Task == quest {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validate ищет ошибки в мире: недостижимые комнаты, двери без переходов,
+// одинаковые предметы в одной комнате и задачи, которые нельзя выполнить
+func (w *World) validate() []string {
+	problems := make([]string, 0)
+	reach := w.reachable()
+
+	for _, name := range sortedKeys(w.Rooms) {
+		room := w.Rooms[name]
+		if !reach[room] {
+			problems = append(problems, fmt.Sprintf("room %q is unreachable from start %q", name, roomName(w.start)))
+		}
+
+		for _, door := range room.Doors {
+			a, b := door.RoomConnect[0], door.RoomConnect[1]
+			if a == nil || b == nil {
+				problems = append(problems, fmt.Sprintf("door %q in room %q does not connect two rooms", door.Name, name))
+				continue
+			}
+			if room != a && room != b {
+				problems = append(problems, fmt.Sprintf("door %q in room %q connects %q and %q", door.Name, name, a.Name, b.Name))
+			}
+		}
+		for _, next := range room.NextRooms {
+			if room.doorTo(next) == nil {
+				problems = append(problems, fmt.Sprintf("passage from %q to %q has no door", name, next.Name))
+			}
+		}
+		seen := map[string]bool{}
+		for _, f := range room.FurnitureInIt {
+			for _, t := range f.ThingsOnIt {
+				if seen[t.getName()] {
+					problems = append(problems, fmt.Sprintf("duplicate item %q in room %q", t.getName(), name))
+				}
+				seen[t.getName()] = true
+			}
+		}
+	}
+
+	for _, door := range w.doors() {
+		a, b := door.RoomConnect[0], door.RoomConnect[1]
+		if a != nil && b != nil && !linked(a, b) && !linked(b, a) {
+			problems = append(problems, fmt.Sprintf("door %q connects %q and %q, but there is no passage between them", door.Name, a.Name, b.Name))
+		}
+	}
+
+	items := w.obtainable()
+	for _, q := range w.quests {
+		if len(q.Conditions) == 0 && !w.completedByRule(q.Name) {
+			problems = append(problems, fmt.Sprintf("quest %q has no conditions and no rule completes it", q.Name))
+		}
+		for _, c := range q.Conditions {
+			switch {
+			case c.Wear != "" && !items[c.Wear]:
+				problems = append(problems, fmt.Sprintf("quest %q: item %q does not exist", q.Name, c.Wear))
+			case c.Have != "" && !items[c.Have]:
+				problems = append(problems, fmt.Sprintf("quest %q: item %q does not exist", q.Name, c.Have))
+			case c.Reach != "" && !reach[w.Rooms[c.Reach]]:
+				problems = append(problems, fmt.Sprintf("quest %q: room %q can't be reached", q.Name, c.Reach))
+			case c.Wear == "" && c.Have == "" && c.Reach == "":
+				problems = append(problems, fmt.Sprintf("quest %q: empty condition", q.Name))
+			}
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// writeDOT выгружает граф комнат в формате Graphviz: ребра - переходы, подпись - дверь
+// закрытые двери рисуются пунктиром, стартовая комната - жирным
+func (w *World) writeDOT(out io.Writer) error {
+	lines := []string{"digraph world {"}
+	for _, name := range sortedKeys(w.Rooms) {
+		attrs := ""
+		if w.Rooms[name] == w.start {
+			attrs = " [style=bold]"
+		}
+		lines = append(lines, "\t"+strconv.Quote(name)+attrs+";")
+	}
+	for _, name := range sortedKeys(w.Rooms) {
+		room := w.Rooms[name]
+		for _, next := range room.NextRooms {
+			edge := "\t" + strconv.Quote(name) + " -> " + strconv.Quote(next.Name)
+			for _, door := range room.Doors {
+				if door.RoomConnect[0] != next && door.RoomConnect[1] != next {
+					continue
+				}
+				edge += " [label=" + strconv.Quote(door.Name)
+				if !door.Status {
+					edge += ", style=dashed"
+				}
+				edge += "]"
+				break
+			}
+			lines = append(lines, edge+";")
+		}
+	}
+	lines = append(lines, "}")
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(out, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportDOT(w *World, path string) error {
+	if path == "-" {
+		return w.writeDOT(os.Stdout)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := w.writeDOT(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
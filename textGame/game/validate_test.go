@@ -0,0 +1,102 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const brokenWorld = `{
+	"start": "кухня",
+	"quests": [
+		{"name": "поесть"},
+		{"name": "найти шапку", "conditions": [{"wear": "шапка"}]},
+		{"name": "дойти до чердака", "conditions": [{"reach": "чердак"}]},
+		{"name": "взять чай", "conditions": [{"have": "чай"}]}
+	],
+	"rooms": [
+		{"name": "кухня", "furniture": [
+			{"name": "стол", "things": ["ложка"]},
+			{"name": "полка", "things": ["ложка"]}
+		]},
+		{"name": "коридор"},
+		{"name": "чердак"}
+	],
+	"doors": [
+		{"name": "кухня", "open": true, "rooms": ["кухня", "коридор"]},
+		{"name": "люк", "rooms": ["коридор", "чердак"]}
+	],
+	"links": [
+		{"door": "кухня", "from": "кухня", "to": ["коридор"]},
+		{"door": "люк", "from": "кухня", "to": ["коридор"], "oneWay": true}
+	],
+	"rules": [{"item": "ложка", "target": "стол", "effects": [{"action": "spawn", "furniture": "стол", "thing": "чай"}]}]
+}`
+
+func TestValidate(t *testing.T) {
+	cfg, err := parseWorld([]byte(brokenWorld))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWorld(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`door "люк" connects "коридор" and "чердак", but there is no passage between them`,
+		`door "люк" in room "кухня" connects "коридор" and "чердак"`,
+		`duplicate item "ложка" in room "кухня"`,
+		`quest "дойти до чердака": room "чердак" can't be reached`,
+		`quest "найти шапку": item "шапка" does not exist`,
+		`quest "поесть" has no conditions and no rule completes it`,
+		`room "чердак" is unreachable from start "кухня"`,
+	}
+	if problems := w.validate(); !reflect.DeepEqual(problems, expected) {
+		t.Errorf("validate:\n\tresult:   %q\n\texpected: %q", problems, expected)
+	}
+
+	// у двух дверей одно имя - проверяются обе
+	w.Rooms["кухня"].Doors[0].Name = "люк"
+	if problems := w.validate(); !reflect.DeepEqual(problems, expected) {
+		t.Errorf("validate with same door names:\n\tresult:   %q\n\texpected: %q", problems, expected)
+	}
+}
+
+func TestShippedWorldsValid(t *testing.T) {
+	paths, err := filepath.Glob("levels/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// пустой путь - встроенный default_world.json
+	for _, path := range append(paths, "") {
+		w, err := newWorldFromFile(path)
+		if err != nil {
+			t.Fatal(path, err)
+		}
+		if problems := w.validate(); len(problems) != 0 {
+			t.Errorf("%q: %q", path, problems)
+		}
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	w, err := newWorldFromFile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &strings.Builder{}
+	if err := w.writeDOT(out); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`digraph world {`,
+		`	"кухня" [style=bold];`,
+		`	"коридор" -> "кухня" [label="кухня"];`,
+		`	"коридор" -> "улица" [label="дверь", style=dashed];`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("no %q in:\n%s", line, out.String())
+		}
+	}
+}
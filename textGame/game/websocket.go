@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1" //nolint:gosec // так требует RFC 6455
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// минимальный websocket по RFC 6455: только текстовые сообщения, ping и close
+
+const (
+	wsGUID          = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsMaxPayload    = 64 << 10
+	wsOpContinue    = 0x0
+	wsOpText        = 0x1
+	wsOpClose       = 0x8
+	wsOpPing        = 0x9
+	wsOpPong        = 0xA
+	wsFinBit        = 0x80
+	wsMaskBit       = 0x80
+	wsPayloadLen16  = 126
+	wsPayloadLen64  = 127
+	wsSmallPayload  = 125
+	wsLen16Boundary = 1 << 16
+)
+
+var errWSProtocol = errors.New("websocket protocol error")
+
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	wmu  sync.Mutex
+}
+
+func wsAccept(key string) string {
+	h := sha1.New() //nolint:gosec
+	h.Write([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerContains(h http.Header, name, value string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func upgradeWS(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "websocket upgrade expected", http.StatusBadRequest)
+		return nil, errWSProtocol
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket is not supported", http.StatusInternalServerError)
+		return nil, errWSProtocol
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// сроки от http.Server остаются на соединении, для websocket они не подходят
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAccept(key) + "\r\n\r\n"
+	if _, err := rw.WriteString(resp); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, r: rw.Reader}, nil
+}
+
+func writeWSFrame(w io.Writer, opcode byte, payload []byte, mask []byte) error {
+	header := []byte{wsFinBit | opcode, 0}
+	maskBit := byte(0)
+	if mask != nil {
+		maskBit = wsMaskBit
+	}
+	switch n := len(payload); {
+	case n <= wsSmallPayload:
+		header[1] = maskBit | byte(n)
+	case n < wsLen16Boundary:
+		header[1] = maskBit | wsPayloadLen16
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = maskBit | wsPayloadLen64
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	if mask != nil {
+		header = append(header, mask...)
+		masked := make([]byte, len(payload))
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = masked
+	}
+	if _, err := w.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readWSFrame читает кадр, wantMask - должен ли он быть замаскирован:
+// кадры от клиента маскируются всегда, от сервера - никогда
+func readWSFrame(r io.Reader, wantMask bool) (fin bool, opcode byte, payload []byte, err error) {
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+	fin = header[0]&wsFinBit != 0
+	opcode = header[0] & 0x0F
+	masked := header[1]&wsMaskBit != 0
+	if masked != wantMask {
+		err = errWSProtocol
+		return
+	}
+	n := uint64(header[1] &^ wsMaskBit)
+	switch n {
+	case wsPayloadLen16:
+		ext := make([]byte, 2)
+		if _, err = io.ReadFull(r, ext); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext))
+	case wsPayloadLen64:
+		ext := make([]byte, 8)
+		if _, err = io.ReadFull(r, ext); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext)
+	}
+	if n > wsMaxPayload {
+		err = errWSProtocol
+		return
+	}
+	mask := make([]byte, 4)
+	if masked {
+		if _, err = io.ReadFull(r, mask); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// readMessage возвращает следующее текстовое сообщение, на ping отвечает сам
+func (c *wsConn) readMessage() (string, error) {
+	msg := make([]byte, 0)
+	for {
+		fin, opcode, payload, err := readWSFrame(c.r, true)
+		if err != nil {
+			return "", err
+		}
+		switch opcode {
+		case wsOpText, wsOpContinue:
+			msg = append(msg, payload...)
+			if len(msg) > wsMaxPayload {
+				return "", errWSProtocol
+			}
+			if fin {
+				return string(msg), nil
+			}
+		case wsOpPing:
+			if err := c.write(wsOpPong, payload); err != nil {
+				return "", err
+			}
+		case wsOpPong:
+		case wsOpClose:
+			_ = c.write(wsOpClose, nil)
+			return "", io.EOF
+		default:
+			return "", errWSProtocol
+		}
+	}
+}
+
+func (c *wsConn) write(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+	return writeWSFrame(c.conn, opcode, payload, nil)
+}
+
+func (c *wsConn) writeMessage(text []byte) error {
+	return c.write(wsOpText, text)
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultPlayer = "ты"
+
+var world *World
+
+//go:embed default_world.json
+var defaultWorld []byte
+
+// описание мира в файле, из него initGame собирает комнаты
+type worldConfig struct {
+	Start  string        `json:"start"`
+	Quests []Quest       `json:"quests"`
+	Rooms  []roomConfig  `json:"rooms"`
+	Doors  []doorConfig  `json:"doors"`
+	Links  []linkConfig  `json:"links"`
+	Rules  []ruleConfig  `json:"rules"`
+	Items  []itemConfig  `json:"items"`
+	Events []eventConfig `json:"events"`
+	NPCs   []npcConfig   `json:"npcs"`
+}
+
+type roomConfig struct {
+	Name        string            `json:"name"`
+	Info        string            `json:"info"`
+	InfoMoved   string            `json:"infoMoved"`
+	ShowTasks   bool              `json:"showTasks"`
+	Forms       nounForms         `json:"forms"`
+	Preposition string            `json:"preposition"`
+	Furniture   []furnitureConfig `json:"furniture"`
+}
+
+type furnitureConfig struct {
+	Name        string    `json:"name"`
+	Forms       nounForms `json:"forms"`
+	Preposition string    `json:"preposition"`
+	Things      []string  `json:"things"`
+}
+
+func (w *World) newFurniture(fc furnitureConfig) Furniture {
+	return Furniture{
+		Name:        fc.Name,
+		ThingsOnIt:  w.newThings(fc.Things),
+		Forms:       fc.Forms,
+		Preposition: fc.Preposition,
+	}
+}
+
+type doorConfig struct {
+	Name  string    `json:"name"`
+	Open  bool      `json:"open"`
+	Rooms [2]string `json:"rooms"`
+}
+
+// переход из комнаты from в комнаты to через дверь door
+// oneWay - переход только в одну сторону, replace - как у roomConnectSet:
+// в одну сторону, и из from можно пройти только в to
+type linkConfig struct {
+	Door    string   `json:"door"`
+	From    string   `json:"from"`
+	To      []string `json:"to"`
+	OneWay  bool     `json:"oneWay"`
+	Replace bool     `json:"replace"`
+}
+
+func parseWorld(data []byte) (*worldConfig, error) {
+	cfg := &worldConfig{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("bad world file: %w", err)
+	}
+	return cfg, nil
+}
+
+func loadWorld(path string) (*worldConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseWorld(data)
+}
+
+// build собирает комнаты по описанию и возвращает стартовую комнату
+func (cfg *worldConfig) build(w *World) (map[string]*Room, *Room, error) {
+	rooms := make(map[string]*Room, len(cfg.Rooms))
+	for _, rc := range cfg.Rooms {
+		if _, ok := rooms[rc.Name]; ok {
+			return nil, nil, fmt.Errorf("room %q defined twice", rc.Name)
+		}
+		room := &Room{
+			Name:        rc.Name,
+			Info:        rc.Info,
+			InfoMoved:   rc.InfoMoved,
+			ShowTasks:   rc.ShowTasks,
+			Forms:       rc.Forms,
+			Preposition: rc.Preposition,
+		}
+		for _, fc := range rc.Furniture {
+			room.addFurniture(w.newFurniture(fc))
+		}
+		rooms[rc.Name] = room
+	}
+
+	getRoom := func(name string) (*Room, error) {
+		room, ok := rooms[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown room %q", name)
+		}
+		return room, nil
+	}
+
+	doors := make(map[string]*Door, len(cfg.Doors))
+	for _, dc := range cfg.Doors {
+		if _, ok := doors[dc.Name]; ok {
+			return nil, nil, fmt.Errorf("door %q defined twice", dc.Name)
+		}
+		door := &Door{Name: dc.Name, Status: dc.Open}
+		for i, name := range dc.Rooms {
+			room, err := getRoom(name)
+			if err != nil {
+				return nil, nil, err
+			}
+			door.RoomConnect[i] = room
+		}
+		doors[dc.Name] = door
+	}
+
+	for _, lc := range cfg.Links {
+		door, ok := doors[lc.Door]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown door %q", lc.Door)
+		}
+		from, err := getRoom(lc.From)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, name := range lc.To {
+			to, err := getRoom(name)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch {
+			case lc.Replace:
+				roomConnectSet(door, from, to)
+			case lc.OneWay:
+				roomConnectOneWay(door, from, to)
+			default:
+				roomConnect(door, from, to)
+			}
+		}
+	}
+
+	for i := range cfg.Rules {
+		if err := cfg.Rules[i].check(rooms, doors); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	start, err := getRoom(cfg.Start)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rooms, start, nil
+}
+
+// World - общие для всех игроков комнаты и сами игроки
+type World struct {
+	Rooms   map[string]*Room
+	Players map[string]*Player
+	start   *Room
+	quests  []Quest
+	rules   []ruleConfig
+	items   map[string]itemConfig
+	saveDir string
+	noSaves bool // у сессий на сервере нет своего места на диске
+	turn    int
+	events  []*scheduledEvent
+	npcs    []*NPC
+	clock   func() time.Time
+	started time.Time
+	journal []journalEntry
+	undo    map[string][]undoEntry
+	seq     int
+
+	journalSkipped int
+}
+
+func newWorld(cfg *worldConfig) (*World, error) {
+	w := &World{
+		Players: make(map[string]*Player),
+		quests:  cfg.Quests,
+		rules:   cfg.Rules,
+		items:   make(map[string]itemConfig, len(cfg.Items)),
+		clock:   time.Now,
+	}
+	w.started = w.clock()
+	for _, item := range cfg.Items {
+		w.items[item.Name] = item
+	}
+	rooms, start, err := cfg.build(w)
+	if err != nil {
+		return nil, err
+	}
+	w.Rooms = rooms
+	w.start = start
+	if err := w.buildEvents(cfg, w.doorsByName()); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// addPlayer возвращает игрока по имени, новый игрок появляется в стартовой комнате
+func (w *World) addPlayer(name string) *Player {
+	if p, ok := w.Players[name]; ok {
+		return p
+	}
+	p := &Player{Name: name, backpackRule: !w.capacityConfigured()}
+	for _, q := range w.quests {
+		p.addQuest(q)
+	}
+	p.setPlace(w.start)
+	w.Players[name] = p
+	return p
+}
+
+// doors - все двери мира, каждая по одному разу, в порядке имен комнат
+// имена дверей могут совпадать, поэтому отличаем их по указателю
+func (w *World) doors() []*Door {
+	seen := make(map[*Door]bool)
+	doors := make([]*Door, 0)
+	for _, name := range sortedKeys(w.Rooms) {
+		for _, door := range w.Rooms[name].Doors {
+			if !seen[door] {
+				seen[door] = true
+				doors = append(doors, door)
+			}
+		}
+	}
+	return doors
+}
+
+// doorsByName нужен при сборке мира, где имена дверей уникальны
+func (w *World) doorsByName() map[string]*Door {
+	doors := make(map[string]*Door)
+	for _, door := range w.doors() {
+		doors[door.Name] = door
+	}
+	return doors
+}
+
+// othersInRoom перечисляет персонажей и остальных игроков в комнате игрока p
+func (w *World) othersInRoom(p *Player) string {
+	names := make([]string, 0)
+	for name, other := range w.Players {
+		if other != p && other.Place == p.Place {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	names = append(w.npcsIn(p.Place), names...)
+	if len(names) == 0 {
+		return ""
+	}
+	return ". кроме тебя здесь: " + strings.Join(names, ", ")
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// gameStep - команда и ответ, который на нее ожидается
+type gameStep struct {
+	command string
+	answer  string
+}
+
+// checkSteps выполняет команды за игрока и сверяет ответы
+func checkSteps(t *testing.T, w *World, player string, steps []gameStep) {
+	t.Helper()
+	for i, step := range steps {
+		if answer := w.handleCommand(player, step.command); answer != step.answer {
+			t.Error("step:", i, "cmd:", step.command,
+				"\n\tresult:  ", answer,
+				"\n\texpected:", step.answer)
+		}
+	}
+}
+
+func TestInitGameFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "world.json")
+	if err := os.WriteFile(path, defaultWorld, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for caseNum, commands := range game0cases {
+		if err := initGameFromFile(path); err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range commands {
+			if answer := handleCommand(item.command); answer != item.answer {
+				t.Error("case:", caseNum, item.step, "cmd:", item.command, "result:", answer)
+			}
+		}
+	}
+}
+
+func TestBadWorld(t *testing.T) {
+	cases := []string{
+		`{"start": "кухня"}`,
+		`{"start": "a", "rooms": [{"name": "a"}, {"name": "a"}]}`,
+		`{"start": "a", "rooms": [{"name": "a"}], "doors": [{"name": "d", "rooms": ["a", "b"]}]}`,
+		`{"start": "a", "rooms": [{"name": "a"}], "links": [{"door": "d", "from": "a", "to": ["a"]}]}`,
+		`{"start": "a", "rooms": [{"name": "a"}], "doors": [{"name": "d", "rooms": ["a", "a"]}, {"name": "d", "rooms": ["a", "a"]}]}`,
+		`{"start": `,
+	}
+	for _, data := range cases {
+		cfg, err := parseWorld([]byte(data))
+		if err == nil {
+			_, err = newWorld(cfg)
+		}
+		if err == nil {
+			t.Error("expected error for", data)
+		}
+	}
+}
+
+func TestMultiplayer(t *testing.T) {
+	cfg, err := parseWorld(defaultWorld)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWorld(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		player  string
+		command string
+		answer  string
+	}{
+		{"вася", "идти коридор", "ничего интересного. можно пройти - кухня, комната, улица"},
+		{"вася", "идти комната", "ты в своей комнате. можно пройти - коридор"},
+		{"петя", "идти коридор", "ничего интересного. можно пройти - кухня, комната, улица"},
+		{"петя", "идти комната", "ты в своей комнате. можно пройти - коридор"},
+		{"вася", "надеть рюкзак", "вы надели: рюкзак"},
+		{"петя", "осмотреться", "на столе: ключи, конспекты. можно пройти - коридор. кроме тебя здесь: вася"},
+		{"петя", "надеть рюкзак", "нет такого"},
+		{"вася", "взять ключи", "предмет добавлен в инвентарь: ключи"},
+		{"петя", "идти коридор", "ничего интересного. можно пройти - кухня, комната, улица"},
+		{"петя", "идти кухня", "кухня, ничего интересного. можно пройти - коридор"},
+		{"петя", "осмотреться", "ты находишься на кухне, на столе: чай, надо собрать рюкзак и идти в универ. можно пройти - коридор"},
+		{"вася", "осмотреться", "на столе: конспекты. можно пройти - коридор"},
+	}
+	for i, c := range cases {
+		if answer := w.handleCommand(c.player, c.command); answer != c.answer {
+			t.Error("step:", i, "player:", c.player, "cmd:", c.command,
+				"\n\tresult:  ", answer,
+				"\n\texpected:", c.answer)
+		}
+	}
+}
+
+func TestBackpackRule(t *testing.T) {
+	// вместимость не задана - надетая вещь дает брать сколько угодно
+	cfg, err := parseWorld([]byte(`{
+		"start": "кухня",
+		"rooms": [{"name": "кухня", "infoMoved": "кухня", "furniture": [
+			{"name": "стол", "things": ["рюкзак", "ключи", "конспекты", "чай"]}
+		]}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWorld(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []gameStep{
+		{"взять ключи", "некуда класть"},
+		{"надеть рюкзак", "вы надели: рюкзак"},
+		{"взять ключи", "предмет добавлен в инвентарь: ключи"},
+		{"взять конспекты", "предмет добавлен в инвентарь: конспекты"},
+		{"взять чай", "предмет добавлен в инвентарь: чай"},
+		{"инвентарь", "надето: рюкзак. в инвентаре: ключи, конспекты, чай. места: без ограничений"},
+		{"снять рюкзак", "нельзя снять рюкзак, сначала выложи вещи"},
+	}
+	checkSteps(t, w, defaultPlayer, steps)
+}
+
+func TestDoorNotNamedAfterRoom(t *testing.T) {
+	// закрытая дверь на улицу идет раньше открытой двери на кухню
+	cfg, err := parseWorld([]byte(`{
+		"start": "коридор",
+		"rooms": [
+			{"name": "коридор", "infoMoved": "коридор"},
+			{"name": "улица", "infoMoved": "улица"},
+			{"name": "кухня", "infoMoved": "кухня"},
+			{"name": "чулан", "infoMoved": "чулан"}
+		],
+		"doors": [
+			{"name": "входная дверь", "rooms": ["коридор", "улица"]},
+			{"name": "кухня", "open": true, "rooms": ["коридор", "кухня"]}
+		],
+		"links": [
+			{"door": "входная дверь", "from": "коридор", "to": ["улица"]},
+			{"door": "кухня", "from": "коридор", "to": ["кухня"]},
+			{"door": "кухня", "from": "кухня", "to": ["чулан"], "oneWay": true}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWorld(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []gameStep{
+		{"идти улица", "дверь закрыта"},
+		{"идти кухня", "кухня. можно пройти - коридор, чулан"},
+		// дверь "кухня" ведет не в чулан
+		{"идти чулан", "нет пути в чулан"},
+	}
+	checkSteps(t, w, defaultPlayer, steps)
+
+	expected := []string{
+		`door "кухня" in room "чулан" connects "коридор" and "кухня"`,
+		`passage from "кухня" to "чулан" has no door`,
+	}
+	if problems := w.validate(); !reflect.DeepEqual(problems, expected) {
+		t.Errorf("validate:\n\tresult:   %q\n\texpected: %q", problems, expected)
+	}
+}